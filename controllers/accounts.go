@@ -0,0 +1,345 @@
+package controllers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/sdwalsh/mirango-go/gpg"
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+var errDisabled = errors.New("user is disabled")
+
+// disabled writes 403 and returns true if the account was disabled by an admin
+func disabled(w http.ResponseWriter, u *models.User) bool {
+	if u.DisabledAt == nil {
+		return false
+	}
+	http.Error(w, "account disabled", 403)
+	return true
+}
+
+// reauthWindow is how long after logging in users without a password can make sensitive
+// changes to their account
+const reauthWindow = 10 * time.Minute
+
+// checkPassword verifies the signed in user's current password before a sensitive change,
+// it writes 401 (or 500) and returns false when the password does not match. Accounts
+// without a password (provisioned through OpenID Connect) prove who they are by logging in
+// through their linked identity again, the session has to be younger than reauthWindow
+func (env *Env) checkPassword(w http.ResponseWriter, r *http.Request, u *models.User, password string) bool {
+	if len(u.Digest) == 0 {
+		session, ok := r.Context().Value(contextSession).(*models.Session)
+		if !ok || time.Since(session.CreatedAt) > reauthWindow {
+			http.Error(w, "log in again to confirm this change", http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	ok, _, err := env.Passwords.Verify(password, string(u.Digest))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// GetProfile returns the public profile of the user matching the uname url param
+func (env *Env) GetProfile(w http.ResponseWriter, r *http.Request) {
+	u, err := env.DB.GetUserByUname(chi.URLParam(r, "uname"))
+	if err != nil || u.DisabledAt != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(u.View())
+}
+
+// GetAccount returns the signed in user's profile including the gpg key fingerprint
+func (env *Env) GetAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// UpdateAccount takes current_password and any of email, password, password2 and gpg from a
+// form and updates the signed in user, an empty gpg field removes the key. A new email is only
+// used once verified (202), changing the password signs out every other session. Users
+// without a password skip current_password (see checkPassword) and can set one here
+func (env *Env) UpdateAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	s := bluemonday.UGCPolicy()
+	user := ctx.Value(contextUser).(*models.User)
+	err := r.ParseForm()
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// Every change requires the current password
+	if !env.checkPassword(w, r, user, r.PostFormValue("current_password")) {
+		return
+	}
+
+	digest := user.Digest
+	password := r.PostFormValue("password")
+	if password != "" {
+		if password != r.PostFormValue("password2") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		digest, err = env.hashPassword(password)
+		if err != nil {
+			env.log(r, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	gpgKey, fingerprint := user.GpgKey, user.GpgFingerprint
+	if _, ok := r.PostForm["gpg"]; ok {
+		gpgKey, fingerprint = r.PostFormValue("gpg"), ""
+		if gpgKey != "" {
+			fingerprint, err = gpg.Fingerprint(gpgKey)
+			if err != nil {
+				env.log(r, err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	u, err := env.DB.UpdateUser(user.ID, digest, gpgKey, fingerprint)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	env.audit(r, user, ActionAccountUpdate, targetUser, &user.ID, user, u)
+	if password != "" {
+		env.audit(r, user, ActionPasswordChange, targetUser, &user.ID, nil, nil)
+		except := uuid.Nil
+		if session, ok := ctx.Value(contextSession).(*models.Session); ok {
+			except = session.ID
+		}
+		_, err = env.DB.RevokeUserSessions(user.ID, except)
+		if err != nil {
+			env.log(r, err)
+		}
+	}
+
+	status := http.StatusOK
+	if email := s.Sanitize(r.PostFormValue("email")); email != "" && email != user.Email {
+		err = env.sendVerification(u, email)
+		if err != nil {
+			env.log(r, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		status = http.StatusAccepted
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(u)
+}
+
+// ExportAccount returns a zip of the signed in user's profile, posts, images, sessions and
+// audit events as JSON files
+func (env *Env) ExportAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	posts, err := env.DB.FindPostsByUser(user.ID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	images, err := env.DB.FindImagesByUser(user.ID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sessions, err := env.DB.GetSessionsByUser(user.ID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	events, err := env.DB.GetAuditEventsByUser(user.ID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", user},
+		{"posts.json", posts},
+		{"images.json", images},
+		{"sessions.json", sessions},
+		{"audit.json", events},
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="account-export.zip"`)
+	w.WriteHeader(http.StatusOK)
+	z := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := z.Create(f.name)
+		if err != nil {
+			env.log(r, err)
+			return
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(f.data); err != nil {
+			env.log(r, err)
+			return
+		}
+	}
+	if err = z.Close(); err != nil {
+		env.log(r, err)
+	}
+}
+
+// DeleteAccount takes current_password from a form and deletes the signed in user, their posts
+// and images are handed to env.DeletedPostsOwner (the ghost user unless configured)
+func (env *Env) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	if !env.checkPassword(w, r, user, r.FormValue("current_password")) {
+		return
+	}
+	// The user taking over posts cannot delete themselves
+	if user.ID == env.DeletedPostsOwner {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	u, err := env.DB.DeleteUser(user.ID, env.DeletedPostsOwner)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// Only the id and uname of a deleted account are kept
+	env.audit(r, user, ActionAccountDelete, targetUser, &u.ID, userRef{u.ID, u.Uname}, nil)
+	clearTokens(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// ListUsers is an admin function that returns users ordered by uname
+// Query string s and e define which rows to query s defaults to 0 and e defaults to s+50
+func (env *Env) ListUsers(w http.ResponseWriter, r *http.Request) {
+	start, end, ok := pageRange(w, r, 50)
+	if !ok {
+		return
+	}
+	users, err := env.DB.ListUsers(start, end)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(users)
+}
+
+// managedUser loads the user from the userID url param for an admin function, admins cannot
+// manage themselves so they never lock themselves out
+func (env *Env) managedUser(w http.ResponseWriter, r *http.Request) (*models.User, *models.User, bool) {
+	admin := r.Context().Value(contextUser).(*models.User)
+	id, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, nil, false
+	}
+	if id == admin.ID {
+		w.WriteHeader(http.StatusConflict)
+		return nil, nil, false
+	}
+	u, err := env.DB.GetUserByID(id)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return nil, nil, false
+	}
+	return admin, u, true
+}
+
+// SetRole is an admin function that takes a role from a form and assigns it to the user
+func (env *Env) SetRole(w http.ResponseWriter, r *http.Request) {
+	role := r.FormValue("role")
+	if !validRole(role) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	admin, user, ok := env.managedUser(w, r)
+	if !ok {
+		return
+	}
+	u, err := env.DB.SetRole(user.ID, role)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	env.audit(r, admin, ActionRoleChange, targetUser, &u.ID, user, u)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(u)
+}
+
+// DisableUser is an admin function that disables the user and revokes their sessions, API
+// tokens stop working as well
+func (env *Env) DisableUser(w http.ResponseWriter, r *http.Request) {
+	env.setDisabled(w, r, true)
+}
+
+// EnableUser is an admin function that lets a disabled user log in again
+func (env *Env) EnableUser(w http.ResponseWriter, r *http.Request) {
+	env.setDisabled(w, r, false)
+}
+
+// setDisabled disables or enables the user from the userID url param
+func (env *Env) setDisabled(w http.ResponseWriter, r *http.Request, disable bool) {
+	admin, user, ok := env.managedUser(w, r)
+	if !ok {
+		return
+	}
+	u, err := env.DB.DisableUser(user.ID, disable)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	action := ActionAccountEnable
+	if disable {
+		action = ActionAccountDisable
+		_, err = env.DB.RevokeUserSessions(u.ID, uuid.Nil)
+		if err != nil {
+			env.log(r, err)
+		}
+	}
+	env.audit(r, admin, action, targetUser, &u.ID, user, u)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(u)
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+func TestCheckPassword(t *testing.T) {
+	store := newFakeStore()
+	env := newTestEnv(t, store)
+	withPassword := store.addUser(t, env, "alice", "correct horse", RoleMember)
+	// Accounts provisioned through OpenID Connect have no password
+	withoutPassword := store.addUser(t, env, "bob", "", RoleMember)
+	withoutPassword.Digest = []byte{}
+
+	fresh := &models.Session{CreatedAt: time.Now().Add(-time.Minute)}
+	stale := &models.Session{CreatedAt: time.Now().Add(-reauthWindow - time.Minute)}
+	tests := []struct {
+		name     string
+		user     *models.User
+		session  *models.Session
+		password string
+		want     bool
+	}{
+		{"current password", withPassword, fresh, "correct horse", true},
+		{"wrong password", withPassword, fresh, "battery staple", false},
+		{"password required after a fresh login", withPassword, fresh, "", false},
+		{"no password and a fresh login", withoutPassword, fresh, "", true},
+		{"no password and an old login", withoutPassword, stale, "", false},
+		{"no password and no session", withoutPassword, nil, "", false},
+		{"no password and a guessed password", withoutPassword, stale, "correct horse", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("PUT", "/account", nil)
+		if tt.session != nil {
+			r = r.WithContext(context.WithValue(r.Context(), contextSession, tt.session))
+		}
+		w := httptest.NewRecorder()
+		if got := env.checkPassword(w, r, tt.user, tt.password); got != tt.want {
+			t.Errorf("%s: checkPassword = %v, want %v", tt.name, got, tt.want)
+		}
+		if !tt.want && w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got %d, want 401", tt.name, w.Code)
+		}
+	}
+}
+
+func TestLoginWithoutPassword(t *testing.T) {
+	store := newFakeStore()
+	env := newTestEnv(t, store)
+	u := store.addUser(t, env, "bob", "", RoleMember)
+	u.Digest = []byte{}
+	for _, password := range []string{"", "anything"} {
+		if w := login(env, "bob", password); w.Code != http.StatusUnauthorized {
+			t.Errorf("password %q: login returned %d, want 401", password, w.Code)
+		}
+	}
+	if len(store.sessions) != 0 {
+		t.Error("a session was started")
+	}
+}
+
+func TestListUsersRange(t *testing.T) {
+	env := newTestEnv(t, newFakeStore())
+	// Bad ranges are rejected before the database is asked
+	for _, query := range []string{"s=-1", "s=20&e=10", "e=-5"} {
+		w := httptest.NewRecorder()
+		env.ListUsers(w, httptest.NewRequest("GET", "/users?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: got %d, want 400", query, w.Code)
+		}
+	}
+}
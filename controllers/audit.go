@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/google/uuid"
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+// Auditor appends events to the audit log, models.DB stores them in audit_events
+type Auditor interface {
+	InsertAuditEvent(e *models.AuditEvent) error
+}
+
+// Audit actions
+const (
+	ActionLogin            = "login"
+	ActionLogout           = "logout"
+	ActionAccountCreate    = "account.create"
+	ActionAccountUnlock    = "account.unlock"
+	ActionAccountUpdate    = "account.update"
+	ActionAccountDelete    = "account.delete"
+	ActionAccountDisable   = "account.disable"
+	ActionAccountEnable    = "account.enable"
+	ActionPasswordChange   = "password.change"
+	ActionIdentityLink     = "identity.link"
+	ActionIdentityUnlink   = "identity.unlink"
+	ActionWebAuthnRegister = "webauthn.register"
+	ActionWebAuthnRemove   = "webauthn.remove"
+	ActionRoleChange       = "role.change"
+	ActionInvitationCreate = "invitation.create"
+	ActionInvitationRevoke = "invitation.revoke"
+	ActionSessionsRevoke   = "sessions.revoke"
+	ActionPostCreate       = "post.create"
+	ActionPostUpdate       = "post.update"
+	ActionPostPublish      = "post.publish"
+	ActionPostUnpublish    = "post.unpublish"
+	ActionPostSchedule     = "post.schedule"
+	ActionPostDelete       = "post.delete"
+	ActionPostRestore      = "post.restore"
+	ActionTagCreate        = "tag.create"
+	ActionTagUpdate        = "tag.update"
+	ActionTagDelete        = "tag.delete"
+)
+
+// Audit target types
+const (
+	targetUser       = "user"
+	targetSession    = "session"
+	targetInvitation = "invitation"
+	targetIdentity   = "identity"
+	targetWebAuthn   = "webauthn_credential"
+	targetPost       = "post"
+	targetTag        = "tag"
+)
+
+// userRef identifies a user in audit diffs without any of their other data
+type userRef struct {
+	ID    uuid.UUID `json:"id"`
+	Uname string    `json:"uname"`
+}
+
+// redacted replaces the values of personal data fields in audit diffs
+const redacted = "[redacted]"
+
+// personalFields are the json fields holding personal data, the audit log is append only
+// (it outlives account deletion) so it only records that they changed
+var personalFields = map[string]bool{
+	"email":           true,
+	"gpg_key":         true,
+	"gpg_fingerprint": true,
+}
+
+// jsonDiff returns the fields that differ between the json encodings of before and after
+// as {"field": [before, after]}, nil before or after is treated as an empty object and the
+// values of personal data fields are redacted
+func jsonDiff(before interface{}, after interface{}) (json.RawMessage, error) {
+	b, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+	diff := make(map[string][2]interface{})
+	for k, v := range b {
+		if !reflect.DeepEqual(v, a[k]) {
+			diff[k] = [2]interface{}{v, a[k]}
+		}
+	}
+	for k, v := range a {
+		if _, ok := b[k]; !ok {
+			diff[k] = [2]interface{}{nil, v}
+		}
+	}
+	for k, d := range diff {
+		if !personalFields[k] {
+			continue
+		}
+		for i, v := range d {
+			if v != nil {
+				d[i] = redacted
+			}
+		}
+		diff[k] = d
+	}
+	return json.Marshal(diff)
+}
+
+// jsonFields decodes the json encoding of v into a map
+func jsonFields(v interface{}) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if v == nil {
+		return fields, nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return fields, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return fields, json.Unmarshal(data, &fields)
+}
+
+// audit records an action taken by actor (nil if nobody is signed in) on the target, before
+// and after are diffed into the event. Failures are logged but never fail the request
+func (env *Env) audit(r *http.Request, actor *models.User, action string, targetType string, target *uuid.UUID, before interface{}, after interface{}) {
+	e := &models.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   target,
+		IPAddress:  remoteIP(r),
+		RequestID:  middleware.GetReqID(r.Context()),
+	}
+	if actor != nil {
+		e.ActorID = &actor.ID
+	}
+	diff, err := jsonDiff(before, after)
+	if err != nil {
+		env.log(r, err)
+	}
+	e.Diff = diff
+	err = env.Audit.InsertAuditEvent(e)
+	if err != nil {
+		env.log(r, err)
+	}
+}
+
+// GetAuditEvents is an admin function that returns audit events newest first filtered by
+// the actor, action, since and until (RFC 3339) query strings
+// Query string s and e define which rows to query s defaults to 0 and e defaults to 50
+func (env *Env) GetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := models.AuditFilter{Action: q.Get("action")}
+	if actor := q.Get("actor"); actor != "" {
+		id, err := uuid.Parse(actor)
+		if err != nil {
+			env.log(r, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.Actor = &id
+	}
+	for param, t := range map[string]**time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := q.Get(param); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				env.log(r, err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			*t = &parsed
+		}
+	}
+	start, end, ok := pageRange(w, r, 50)
+	if !ok {
+		return
+	}
+	f.Start, f.End = start, end
+	events, err := env.DB.GetAuditEvents(f)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(events)
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+func TestJSONDiff(t *testing.T) {
+	id := uuid.New()
+	before := &models.User{ID: id, Uname: "alice", Role: RoleAuthor, Email: "alice@example.com", GpgKey: "old key"}
+	after := &models.User{ID: id, Uname: "alice", Role: RoleEditor, Email: "alice@example.org", GpgKey: ""}
+
+	raw, err := jsonDiff(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var diff map[string][2]interface{}
+	if err := json.Unmarshal(raw, &diff); err != nil {
+		t.Fatal(err)
+	}
+	if got := diff["role"]; got[0] != RoleAuthor || got[1] != RoleEditor {
+		t.Errorf("role diff = %v", got)
+	}
+	if got := diff["email"]; got[0] != redacted || got[1] != redacted {
+		t.Errorf("email diff = %v, want redacted values", got)
+	}
+	if got := diff["gpg_key"]; got[0] != redacted || got[1] != redacted {
+		t.Errorf("gpg_key diff = %v, want redacted values", got)
+	}
+	for _, field := range []string{"id", "uname", "gpg_fingerprint"} {
+		if _, ok := diff[field]; ok {
+			t.Errorf("unchanged field %s in diff", field)
+		}
+	}
+
+	// Fields missing on one side stay null
+	raw, err = jsonDiff(nil, &models.Invitation{Email: "bob@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff = nil
+	json.Unmarshal(raw, &diff)
+	if got := diff["email"]; got[0] != nil || got[1] != redacted {
+		t.Errorf("email diff = %v, want [null, redacted]", got)
+	}
+}
+
+func TestGetAuditEventsRange(t *testing.T) {
+	env := &Env{Sugar: zap.NewNop().Sugar()}
+	for _, query := range []string{"s=-1", "s=10&e=5", "e=-1"} {
+		w := httptest.NewRecorder()
+		env.GetAuditEvents(w, httptest.NewRequest("GET", "/admin/audit?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestJSONDiffUserRef(t *testing.T) {
+	id := uuid.New()
+	raw, err := jsonDiff(userRef{id, "alice"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var diff map[string][2]interface{}
+	if err := json.Unmarshal(raw, &diff); err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 2 || diff["id"][0] != id.String() || diff["uname"][0] != "alice" {
+		t.Errorf("diff = %v, want only id and uname", diff)
+	}
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/sdwalsh/mirango-go/models"
+	"github.com/sdwalsh/mirango-go/token"
+)
+
+// startSession records a new session for the user and hands out its tokens, the session
+// (and its refresh tokens) lasts env.RefreshTTL
+func (env *Env) startSession(w http.ResponseWriter, r *http.Request, u *models.User) (*models.Session, error) {
+	session, err := env.DB.CreateSession(u.ID, remoteIP(r), r.UserAgent(), time.Now().Add(env.RefreshTTL))
+	if err != nil {
+		return nil, err
+	}
+	env.loginSucceeded(r, u)
+	env.audit(r, u, ActionLogin, targetSession, &session.ID, nil, nil)
+	return session, env.issueTokens(w, session)
+}
+
+// issueTokens sets a short lived access jwt and a new single use refresh token for the session
+func (env *Env) issueTokens(w http.ResponseWriter, session *models.Session) error {
+	t := time.Now().Add(env.AccessTTL)
+	tokenString, err := env.Tokens.Sign(UserCustomClaim{
+		session.UserID,
+		env.Tokens.Standard(session.ID.String(), env.AccessTTL),
+	})
+	if err != nil {
+		return err
+	}
+	plain, hash, err := token.Opaque()
+	if err != nil {
+		return err
+	}
+	_, err = env.DB.InsertRefreshToken(session.ID, hash, session.ExpiredAt)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "authentication",
+		Value:    tokenString,
+		Path:     "/",
+		Expires:  t,
+		HttpOnly: true,
+	})
+	// The refresh token is only ever sent to the refresh endpoint
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh",
+		Value:    plain,
+		Path:     "/auth/refresh",
+		Expires:  session.ExpiredAt,
+		HttpOnly: true,
+	})
+	return nil
+}
+
+// clearTokens deletes the authentication and refresh cookies
+func clearTokens(w http.ResponseWriter) {
+	// Setting MaxAge to < 0 will delete cookie now
+	http.SetCookie(w, &http.Cookie{
+		Name:     "authentication",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh",
+		Path:     "/auth/refresh",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// Refresh exchanges the refresh cookie for a new access token and refresh token. Refresh
+// tokens are single use, replaying one that was already used revokes the whole session
+// since either the client or an attacker holds a stolen copy
+func (env *Env) Refresh(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("refresh")
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	hash := token.Hash(cookie.Value)
+	rt, err := env.DB.UseRefreshToken(hash)
+	if err != nil {
+		env.log(r, err)
+		if old, err := env.DB.FindRefreshToken(hash); err == nil && old.UsedAt != nil {
+			env.Sugar.Infow("refresh token reused, revoking session",
+				"request ip:", r.RemoteAddr,
+				"session:", old.SessionID,
+			)
+			if _, err := env.DB.RevokeSession(old.SessionID); err != nil {
+				env.log(r, err)
+			}
+		}
+		clearTokens(w)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	// Logged out or expired sessions can no longer be refreshed
+	session, err := env.DB.GetActiveSession(rt.SessionID)
+	if err != nil {
+		env.log(r, err)
+		clearTokens(w)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	err = env.issueTokens(w, session)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
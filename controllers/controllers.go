@@ -2,35 +2,140 @@ package controllers
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/securecookie"
+	"github.com/lib/pq"
+	"github.com/sdwalsh/mirango-go/mailer"
 	"github.com/sdwalsh/mirango-go/models"
+	"github.com/sdwalsh/mirango-go/oidc"
+	"github.com/sdwalsh/mirango-go/password"
+	"github.com/sdwalsh/mirango-go/ratelimit"
+	"github.com/sdwalsh/mirango-go/scheduler"
+	"github.com/sdwalsh/mirango-go/token"
 )
 
 // Env carries database access to controllers
 type Env struct {
-	DB    models.Datastore
-	S     *securecookie.SecureCookie
-	Hmac  []byte
-	Salt  string
-	Sugar *zap.SugaredLogger
+	DB      models.Datastore
+	Limiter ratelimit.Store
+	// FallbackLimiter counts attempts while Limiter is failing (rate limited requests are
+	// rejected when it is nil)
+	FallbackLimiter ratelimit.Store
+	Audit           Auditor
+	S               *securecookie.SecureCookie
+	Tokens          *token.Keyring
+	AccessTTL       time.Duration
+	RefreshTTL      time.Duration
+	// RequireAdmin2FA blocks routes behind Require for ADMIN users without two factor enabled
+	RequireAdmin2FA bool
+	Lockout         Lockout
+	Mailer          mailer.Mailer
+	// BaseURL is the public address links in emails point to
+	BaseURL   string
+	Passwords password.Hasher
+	// OIDC is the OpenID Connect issuer users can log in with (nil when not configured),
+	// unknown identities get an account with OIDCProvisionRole unless it is empty
+	OIDC              *oidc.Provider
+	OIDCProvisionRole string
+	// WebAuthn handles passkey registration and login (nil when not configured)
+	WebAuthn *webauthn.WebAuthn
+	// Only the newest RevisionLimit revisions of a post are kept and none older than
+	// RevisionMaxAge (0 disables either limit)
+	RevisionLimit  int
+	RevisionMaxAge time.Duration
+	// PublishHooks are called after a user publishes a post, the scheduler calls the same hooks
+	PublishHooks []scheduler.Hook
+	// DeletedPostsOwner takes over the posts and images of deleted accounts, uuid.Nil is
+	// the ghost user that anonymizes them
+	DeletedPostsOwner uuid.UUID
+	Sugar             *zap.SugaredLogger
+}
+
+// requestInfo is what the error log records about a request. Work a handler leaves running
+// in the background logs through it since the *http.Request must not be used once the
+// handler has returned
+type requestInfo struct {
+	RemoteAddr string
+	UserAgent  string
+	Method     string
+	Path       string
+	RequestID  string
+}
+
+// describe captures the requestInfo of r. Headers and the query string are left out, they
+// carry bearer tokens, session cookies and the tokens from emailed links
+func describe(r *http.Request) requestInfo {
+	return requestInfo{
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RequestID:  middleware.GetReqID(r.Context()),
+	}
 }
 
 // Helper to log any errors
 func (env *Env) log(r *http.Request, err error) {
+	env.logRequest(describe(r), err)
+}
+
+// logRequest logs an error of the described request
+func (env *Env) logRequest(info requestInfo, err error) {
 	env.Sugar.Infow("error encountered during controller",
-		"request ip:", r.RemoteAddr,
-		"header:", r.Header,
-		"method:", r.Method,
-		"url:", r.URL,
+		"request ip:", info.RemoteAddr,
+		"user agent:", info.UserAgent,
+		"method:", info.Method,
+		"path:", info.Path,
+		"request id:", info.RequestID,
 		"error:", err,
 	)
 }
 
+// remoteIP strips the port from the request's remote address so it can be stored as an inet
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// RealIP sets RemoteAddr without a port
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// pageRange reads the s and e query strings selecting rows s to e, s defaults to 0 and e to
+// s + size. It writes 400 and returns false for negative or inverted ranges
+func pageRange(w http.ResponseWriter, r *http.Request, size int) (int, int, bool) {
+	q := r.URL.Query()
+	start, err := strconv.Atoi(q.Get("s"))
+	if err != nil {
+		start = 0
+	}
+	end, err := strconv.Atoi(q.Get("e"))
+	if err != nil {
+		end = start + size
+	}
+	if start < 0 || end < start {
+		w.WriteHeader(http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// uniqueViolation returns true if err is a PostgreSQL unique constraint violation
+func uniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
 // Nonspecific routes go here
 
 // Dashboard is a function that wraps calls commonly used on the homepage
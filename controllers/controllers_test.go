@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogOmitsSecrets(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	env := &Env{Sugar: zap.New(core).Sugar()}
+	r := httptest.NewRequest("GET", "/auth/email/verify?token=link-secret", nil)
+	r.Header.Set("Authorization", "Bearer mrg_bearer-secret")
+	r.Header.Set("Cookie", "authentication=cookie-secret")
+	r.Header.Set("User-Agent", "log-test")
+	env.log(r, errors.New("failed"))
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d log entries, want 1", len(entries))
+	}
+	fields := fmt.Sprint(entries[0].ContextMap())
+	for _, secret := range []string{"link-secret", "bearer-secret", "cookie-secret"} {
+		if strings.Contains(fields, secret) {
+			t.Errorf("log entry contains %q: %s", secret, fields)
+		}
+	}
+	for _, want := range []string{"log-test", "/auth/email/verify", "failed"} {
+		if !strings.Contains(fields, want) {
+			t.Errorf("log entry is missing %q: %s", want, fields)
+		}
+	}
+}
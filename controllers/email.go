@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/sdwalsh/mirango-go/mailer"
+	"github.com/sdwalsh/mirango-go/models"
+	"github.com/sdwalsh/mirango-go/token"
+)
+
+const (
+	// emailVerificationTTL is how long a verification link stays valid
+	emailVerificationTTL = 48 * time.Hour
+	// emailResendCooldown is how long a user has to wait between verification emails
+	emailResendCooldown = 5 * time.Minute
+)
+
+// sendVerification mails a verification link for email to the user, email becomes the
+// user's address once the link is followed
+func (env *Env) sendVerification(u *models.User, email string) error {
+	plain, hash, err := token.Opaque()
+	if err != nil {
+		return err
+	}
+	_, err = env.DB.InsertEmailVerification(u.ID, email, hash, time.Now().Add(emailVerificationTTL))
+	if err != nil {
+		return err
+	}
+	return env.Mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: "Please confirm " + email + " is the email address for " + u.Uname + " by following this link:\n\n" +
+			env.BaseURL + "/verify-email?token=" + plain + "\n\n" +
+			"If you did not expect this email you can ignore it.\n",
+	})
+}
+
+// VerifiedOnly blocks requests from users who have not verified their email address
+// assumes user is stored in context (run UserCtx before running this middleware)
+func (env *Env) VerifiedOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(contextUser).(*models.User)
+		if !ok {
+			http.Error(w, http.StatusText(401), 401)
+			return
+		}
+		if user.EmailVerifiedAt == nil {
+			http.Error(w, "email address not verified", 403)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// VerifyEmail takes a token from a form and marks the address it was sent to as verified
+func (env *Env) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	_, err := env.DB.VerifyEmail(token.Hash(r.FormValue("token")))
+	if uniqueViolation(err) {
+		// Another account verified the address in the meantime
+		env.log(r, err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ResendVerification sends a new link for the signed in user's pending address (a pending email
+// change or their unverified email), users have to wait emailResendCooldown between emails
+func (env *Env) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	email := user.Email
+	latest, err := env.DB.LatestEmailVerification(user.ID)
+	if err == nil {
+		if wait := time.Until(latest.CreatedAt.Add(emailResendCooldown)); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if latest.UsedAt == nil {
+			email = latest.Email
+		}
+	}
+	if email == user.Email && user.EmailVerifiedAt != nil {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	err = env.sendVerification(user, email)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ChangeEmail takes current_password and an email from a form and sends a verification link
+// to the email, the signed in user's email only changes once the link is followed
+func (env *Env) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	s := bluemonday.UGCPolicy()
+	user := ctx.Value(contextUser).(*models.User)
+	email := s.Sanitize(r.FormValue("email"))
+	if email == "" || email == user.Email {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// A stolen session alone is not enough to move the account to another address
+	if !env.checkPassword(w, r, user, r.FormValue("current_password")) {
+		return
+	}
+	err := env.sendVerification(user, email)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/sdwalsh/mirango-go/gpg"
+)
+
+// gpgChallengeTTL is how long a user has to sign a login challenge
+const gpgChallengeTTL = 5 * time.Minute
+
+// GPGChallenge takes a user from a form and returns a single use challenge the user has to
+// clearsign with the gpg key stored on their account (e.g. gpg --clearsign)
+func (env *Env) GPGChallenge(w http.ResponseWriter, r *http.Request) {
+	s := bluemonday.UGCPolicy()
+	uname := s.Sanitize(r.FormValue("user"))
+	u, err := env.DB.GetUserByUname(uname)
+	if err != nil || u.GpgFingerprint == "" {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	t := time.Now()
+	nonce := fmt.Sprintf("mirango login for %s at %s: %s", u.Uname, t.UTC().Format(time.RFC3339), hex.EncodeToString(b))
+	c, err := env.DB.InsertGPGChallenge(u.ID, nonce, t.Add(gpgChallengeTTL))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(c)
+}
+
+// LoginGPG takes challenge_id and signature (the clearsigned challenge) from a form and
+// logs the user in if the signature was made by their stored gpg key
+func (env *Env) LoginGPG(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.FormValue("challenge_id"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// Challenges are used up even when the signature is wrong
+	c, err := env.DB.UseGPGChallenge(id)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	u, err := env.DB.GetUserByID(c.UserID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if locked(w, u) {
+		return
+	}
+	err = gpg.VerifyClearsigned(u.GpgKey, r.FormValue("signature"), c.Nonce)
+	if err != nil {
+		env.log(r, err)
+		env.loginFailed(r, u)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	env.completeLogin(w, r, u)
+}
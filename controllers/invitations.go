@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/sdwalsh/mirango-go/gpg"
+	"github.com/sdwalsh/mirango-go/mailer"
+	"github.com/sdwalsh/mirango-go/models"
+	"github.com/sdwalsh/mirango-go/token"
+)
+
+const (
+	// invitationTTL is how long an invitation stays valid unless expires_in is given
+	invitationTTL = 7 * 24 * time.Hour
+	// invitationMaxTTL is the longest expires_in accepted for an invitation
+	invitationMaxTTL = 30 * 24 * time.Hour
+)
+
+// invitationView adds the status of the invitation
+type invitationView struct {
+	models.Invitation
+	Status string `json:"status"`
+}
+
+// CreateInvitation is an admin function that takes email, an optional role (defaults to MEMBER)
+// and an optional expires_in duration from a form and mails a single use invitation link
+func (env *Env) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	s := bluemonday.UGCPolicy()
+	admin := ctx.Value(contextUser).(*models.User)
+	email := s.Sanitize(r.FormValue("email"))
+	role := r.FormValue("role")
+	if role == "" {
+		role = RoleMember
+	}
+	if email == "" || !validRole(role) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ttl := invitationTTL
+	if v := r.FormValue("expires_in"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > invitationMaxTTL {
+			env.log(r, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+	plain, hash, err := token.Opaque()
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	i, err := env.DB.InsertInvitation(admin.ID, email, role, hash, time.Now().Add(ttl))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = env.Mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "You have been invited",
+		Body: admin.Uname + " invited you to create an account. Follow this link to choose your username and password:\n\n" +
+			env.BaseURL + "/accept-invitation?token=" + plain + "\n\n" +
+			"The invitation expires on " + i.ExpiredAt.UTC().Format(time.RFC1123) + ".\n",
+	})
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	env.audit(r, admin, ActionInvitationCreate, targetInvitation, &i.ID, nil, i)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invitationView{*i, i.Status()})
+}
+
+// GetInvitations is an admin function that returns invitations with their status newest first
+// Query string s and e define which rows to query s defaults to 0 and e defaults to s+50
+func (env *Env) GetInvitations(w http.ResponseWriter, r *http.Request) {
+	start, end, ok := pageRange(w, r, 50)
+	if !ok {
+		return
+	}
+	invitations, err := env.DB.GetInvitations(start, end)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	views := make([]invitationView, 0, len(*invitations))
+	for _, i := range *invitations {
+		views = append(views, invitationView{i, i.Status()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(views)
+}
+
+// RevokeInvitation is an admin function that revokes an invitation that was not accepted yet
+func (env *Env) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	admin := ctx.Value(contextUser).(*models.User)
+	id, err := uuid.Parse(chi.URLParam(r, "invitationID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	i, err := env.DB.RevokeInvitation(id)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	env.audit(r, admin, ActionInvitationRevoke, targetInvitation, &i.ID, nil, nil)
+	w.WriteHeader(http.StatusOK)
+}
+
+// AcceptInvitation takes token, user, password, password2 and an optional gpg key from a form
+// and creates the invited account with the role chosen by the admin, returns 401 if the
+// invitation is not pending and 409 if the user name or email is taken
+func (env *Env) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	// Clean everything but the password (password is hashed) and gpg key (validated below)
+	s := bluemonday.UGCPolicy()
+	user := s.Sanitize(r.FormValue("user"))
+	gpgKey := r.FormValue("gpg")
+	password := r.FormValue("password")
+	if user == "" || password == "" || password != r.FormValue("password2") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	fingerprint := ""
+	if gpgKey != "" {
+		var err error
+		fingerprint, err = gpg.Fingerprint(gpgKey)
+		if err != nil {
+			env.log(r, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	digest, err := env.hashPassword(password)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	hash := token.Hash(r.FormValue("token"))
+	u, err := env.DB.AcceptInvitation(hash, user, digest, gpgKey, fingerprint)
+	if uniqueViolation(err) {
+		// A taken uname (or email) leaves the invitation pending
+		env.log(r, err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err == sql.ErrNoRows {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	env.audit(r, u, ActionAccountCreate, targetUser, &u.ID, nil, u)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(u)
+}
@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetInvitationsRange(t *testing.T) {
+	env := newTestEnv(t, newFakeStore())
+	// Bad ranges are rejected before the database is asked
+	for _, query := range []string{"s=-1", "s=20&e=10", "e=-5"} {
+		w := httptest.NewRecorder()
+		env.GetInvitations(w, httptest.NewRequest("GET", "/invitations?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: got %d, want 400", query, w.Code)
+		}
+	}
+}
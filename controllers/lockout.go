@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/sdwalsh/mirango-go/mailer"
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+// Lockout is the per account lockout policy, once Threshold consecutive logins failed the
+// account is locked for Base, doubling with every further failure up to Max
+type Lockout struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+}
+
+// duration returns how long an account with the given number of failed logins is locked
+func (l Lockout) duration(failed int) time.Duration {
+	if l.Threshold <= 0 || failed < l.Threshold {
+		return 0
+	}
+	d := l.Base
+	for i := l.Threshold; i < failed && d < l.Max; i++ {
+		d *= 2
+	}
+	if d > l.Max {
+		d = l.Max
+	}
+	return d
+}
+
+// locked writes 423 with a Retry-After header and returns true if the account is locked
+func locked(w http.ResponseWriter, u *models.User) bool {
+	if u.LockedUntil == nil {
+		return false
+	}
+	wait := time.Until(*u.LockedUntil)
+	if wait <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	w.WriteHeader(http.StatusLocked)
+	return true
+}
+
+// loginFailed records a failed login for the user and locks the account once the
+// lockout threshold is reached
+func (env *Env) loginFailed(r *http.Request, u *models.User) {
+	_, err := env.DB.InsertLoginAttempt(u.ID, remoteIP(r), r.UserAgent(), false)
+	if err != nil {
+		env.log(r, err)
+	}
+	u, err = env.DB.IncrementFailedLogins(u.ID)
+	if err != nil {
+		env.log(r, err)
+		return
+	}
+	if d := env.Lockout.duration(u.FailedLogins); d > 0 {
+		env.Sugar.Infow("locking account after failed logins",
+			"request ip:", r.RemoteAddr,
+			"user:", u.ID,
+			"failed logins:", u.FailedLogins,
+		)
+		_, err = env.DB.LockUser(u.ID, time.Now().Add(d))
+		if err != nil {
+			env.log(r, err)
+		}
+	}
+}
+
+// loginSucceeded records a successful login, resets the failed login count and emails the
+// user when they log in from a new network (ip_root) or user agent
+func (env *Env) loginSucceeded(r *http.Request, u *models.User) {
+	ip := remoteIP(r)
+	history, err := env.DB.GetLoginHistory(u.ID, ip, r.UserAgent())
+	if err != nil {
+		env.log(r, err)
+	}
+	_, err = env.DB.InsertLoginAttempt(u.ID, ip, r.UserAgent(), true)
+	if err != nil {
+		env.log(r, err)
+	}
+	if u.FailedLogins > 0 || u.LockedUntil != nil {
+		_, err = env.DB.UnlockUser(u.ID)
+		if err != nil {
+			env.log(r, err)
+		}
+	}
+	// The first login is never reported
+	if history == nil || history.Logins == 0 || (history.KnownIP && history.KnownAgent) {
+		return
+	}
+	// Sent in the background so slow mail neither delays the login nor shows in its timing
+	go env.mail(describe(r), mailer.Message{
+		To:      u.Email,
+		Subject: "New login to your account",
+		Body: "Your account " + u.Uname + " was just used to log in from a new location or device.\n\n" +
+			"IP address: " + ip + "\n" +
+			"User agent: " + r.UserAgent() + "\n" +
+			"Time: " + time.Now().UTC().Format(time.RFC1123) + "\n\n" +
+			"If this was not you, reset your password and sign out your other sessions at " + env.BaseURL + "\n",
+	})
+}
+
+// mail sends the message and logs a failure for the described request (run as a goroutine)
+func (env *Env) mail(info requestInfo, msg mailer.Message) {
+	if err := env.Mailer.Send(msg); err != nil {
+		env.logRequest(info, err)
+	}
+}
+
+// GetLoginAttempts is an admin function that returns the login attempts of the user
+// Query string s and e define which rows to query s defaults to 0 and e defaults to s+50
+func (env *Env) GetLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	start, end, ok := pageRange(w, r, 50)
+	if !ok {
+		return
+	}
+	attempts, err := env.DB.GetLoginAttemptsByUser(id, start, end)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(attempts)
+}
+
+// UnlockAccount is an admin function that lifts the lockout of the user
+func (env *Env) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	_, err = env.DB.UnlockUser(id)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	admin, _ := r.Context().Value(contextUser).(*models.User)
+	env.audit(r, admin, ActionAccountUnlock, targetUser, &id, nil, nil)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+
+	"github.com/sdwalsh/mirango-go/mailer"
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+// blockingMailer hands every message to sent and blocks until the test receives it
+type blockingMailer struct {
+	sent chan mailer.Message
+}
+
+func (m *blockingMailer) Send(msg mailer.Message) error {
+	m.sent <- msg
+	return nil
+}
+
+func TestLoginAlertDoesNotBlock(t *testing.T) {
+	store := newFakeStore()
+	env := newTestEnv(t, store)
+	m := &blockingMailer{sent: make(chan mailer.Message)}
+	env.Mailer = m
+	store.addUser(t, env, "alice", "correct horse", RoleMember)
+	store.history = models.LoginHistory{Logins: 3, KnownIP: true}
+
+	// The mailer blocks until the message is received below, so the login only returns
+	// if the alert is sent in the background
+	done := make(chan int)
+	go func() { done <- login(env, "alice", "correct horse").Code }()
+	select {
+	case code := <-done:
+		if code != http.StatusOK {
+			t.Fatalf("login returned %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("login waited for the new login alert")
+	}
+	select {
+	case msg := <-m.sent:
+		if msg.To != "alice@example.com" || !strings.Contains(msg.Body, "session-test") {
+			t.Errorf("unexpected alert %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no new login alert was sent")
+	}
+}
+
+func TestGetLoginAttemptsRange(t *testing.T) {
+	env := newTestEnv(t, newFakeStore())
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{"", http.StatusOK},
+		{"s=10&e=20", http.StatusOK},
+		{"s=-1", http.StatusBadRequest},
+		{"s=20&e=10", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/users/x/login-attempts?"+tt.query, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("userID", uuid.New().String())
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		env.GetLoginAttempts(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%q: got %d, want %d", tt.query, w.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/sdwalsh/mirango-go/models"
+	"github.com/sdwalsh/mirango-go/oidc"
+)
+
+// oidcTTL is how long a user has to finish logging in at the issuer
+const oidcTTL = 10 * time.Minute
+
+// oidcCookie holds the oidcState between the redirect to the issuer and the callback
+const oidcCookie = "oidc"
+
+var errOIDCState = errors.New("invalid or expired oidc state")
+
+// oidcState is what the callback needs to finish the flow, Link is the signed in user
+// linking the identity (uuid.Nil to log in)
+type oidcState struct {
+	State    string
+	Nonce    string
+	Verifier string
+	Link     uuid.UUID
+	Expires  time.Time
+}
+
+// startOIDC stores a new oidcState in a secure cookie and redirects to the issuer
+func (env *Env) startOIDC(w http.ResponseWriter, r *http.Request, link uuid.UUID) {
+	if env.OIDC == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	st := oidcState{Link: link, Expires: time.Now().Add(oidcTTL)}
+	var err error
+	for _, v := range []*string{&st.State, &st.Nonce, &st.Verifier} {
+		if *v, err = oidc.Random(); err != nil {
+			env.log(r, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	encoded, err := env.S.Encode(oidcCookie, st)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// Lax so the cookie is sent along with the issuer's redirect back to the callback
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    encoded,
+		Path:     "/login/oidc",
+		Expires:  st.Expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, env.OIDC.AuthCodeURL(st.State, st.Nonce, st.Verifier), http.StatusFound)
+}
+
+// readOIDCState decodes and deletes the oidc cookie, the state has to match the callback's
+func (env *Env) readOIDCState(w http.ResponseWriter, r *http.Request) (*oidcState, error) {
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		return nil, err
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/login/oidc", MaxAge: -1, HttpOnly: true})
+	st := new(oidcState)
+	err = env.S.Decode(oidcCookie, cookie.Value, st)
+	if err != nil {
+		return nil, err
+	}
+	state := r.URL.Query().Get("state")
+	if time.Now().After(st.Expires) || subtle.ConstantTimeCompare([]byte(st.State), []byte(state)) != 1 {
+		return nil, errOIDCState
+	}
+	return st, nil
+}
+
+// LoginOIDC redirects to the OpenID Connect issuer to log in
+func (env *Env) LoginOIDC(w http.ResponseWriter, r *http.Request) {
+	env.startOIDC(w, r, uuid.Nil)
+}
+
+// LinkOIDC redirects the signed in user to the OpenID Connect issuer to link their identity
+func (env *Env) LinkOIDC(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	env.startOIDC(w, r, user.ID)
+}
+
+// OIDCCallback exchanges the code from the issuer for an ID token and either links the
+// identity to the user that started linking or logs in the user it belongs to. Unknown
+// identities get a new account with env.OIDCProvisionRole when it is set
+func (env *Env) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if env.OIDC == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	st, err := env.readOIDCState(w, r)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		env.log(r, errors.New("oidc: "+e+" "+q.Get("error_description")))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	claims, err := env.OIDC.Exchange(r.Context(), q.Get("code"), st.Verifier, st.Nonce)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if st.Link != uuid.Nil {
+		env.linkIdentity(w, r, st.Link, claims)
+		return
+	}
+
+	var u *models.User
+	identity, err := env.DB.GetUserIdentity(claims.Issuer, claims.Subject)
+	switch {
+	case err == nil:
+		u, err = env.DB.GetUserByID(identity.UserID)
+		if err != nil {
+			env.log(r, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	case err == sql.ErrNoRows && env.OIDCProvisionRole != "":
+		var ok bool
+		if u, ok = env.provisionUser(w, r, claims); !ok {
+			return
+		}
+	default:
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	env.completeLogin(w, r, u)
+}
+
+// linkIdentity links the identity in claims to the user that started linking, who has to
+// still be signed in
+func (env *Env) linkIdentity(w http.ResponseWriter, r *http.Request, link uuid.UUID, claims *oidc.Claims) {
+	user, ok := r.Context().Value(contextUser).(*models.User)
+	if !ok || user.ID != link {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	identity, err := env.DB.InsertUserIdentity(user.ID, claims.Issuer, claims.Subject, claims.Email)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	env.audit(r, user, ActionIdentityLink, targetIdentity, &identity.ID, nil, identity)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(identity)
+}
+
+// provisionUser creates an account for an unknown identity, the issuer has to vouch for the
+// email address. The uname is the preferred_username claim or the local part of the email
+func (env *Env) provisionUser(w http.ResponseWriter, r *http.Request, claims *oidc.Claims) (*models.User, bool) {
+	if claims.Email == "" || !claims.EmailVerified {
+		w.WriteHeader(http.StatusForbidden)
+		return nil, false
+	}
+	s := bluemonday.UGCPolicy()
+	uname := s.Sanitize(claims.PreferredUsername)
+	if uname == "" {
+		uname = s.Sanitize(strings.SplitN(claims.Email, "@", 2)[0])
+	}
+	// The account has no password (an empty digest never verifies), users can set one from
+	// their account settings or through the reset flow
+	email := s.Sanitize(claims.Email)
+	u, err := env.DB.ProvisionUser(claims.Issuer, claims.Subject, uname, []byte{}, env.OIDCProvisionRole, email)
+	if uniqueViolation(err) {
+		// The uname or email belongs to an account the identity is not linked to
+		env.log(r, err)
+		w.WriteHeader(http.StatusConflict)
+		return nil, false
+	}
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	env.audit(r, u, ActionAccountCreate, targetUser, &u.ID, nil, u)
+	return u, true
+}
+
+// GetIdentities returns the external identities linked to the signed in user
+func (env *Env) GetIdentities(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	identities, err := env.DB.GetUserIdentitiesByUser(user.ID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(identities)
+}
+
+// DeleteIdentity unlinks one of the signed in user's external identities, a user without a
+// password cannot unlink their last one (409)
+func (env *Env) DeleteIdentity(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	id, err := uuid.Parse(chi.URLParam(r, "identityID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// Identities belonging to someone else are reported as not found
+	identity, err := env.DB.DeleteUserIdentity(id, user.ID)
+	if err == models.ErrLastIdentity {
+		http.Error(w, "set a password before unlinking your last identity", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	env.audit(r, user, ActionIdentityUnlink, targetIdentity, &identity.ID, identity, nil)
+	w.WriteHeader(http.StatusOK)
+}
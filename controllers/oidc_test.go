@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+// deleteIdentity runs DeleteIdentity for the identity as the user and returns the status
+func deleteIdentity(env *Env, u *models.User, identity uuid.UUID) int {
+	r := httptest.NewRequest("DELETE", "/account/identities/"+identity.String(), nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("identityID", identity.String())
+	ctx := context.WithValue(r.Context(), chi.RouteCtxKey, rctx)
+	r = r.WithContext(context.WithValue(ctx, contextUser, u))
+	w := httptest.NewRecorder()
+	env.DeleteIdentity(w, r)
+	return w.Code
+}
+
+// link stores an identity for the user and returns its id
+func (s *fakeStore) link(u *models.User, subject string) uuid.UUID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := &models.UserIdentity{ID: uuid.New(), UserID: u.ID, Issuer: "https://issuer.example.com", Subject: subject}
+	s.identities[i.ID] = i
+	return i.ID
+}
+
+func TestDeleteIdentity(t *testing.T) {
+	store := newFakeStore()
+	env := newTestEnv(t, store)
+	alice := store.addUser(t, env, "alice", "correct horse", RoleMember)
+	// bob was provisioned through OpenID Connect and has no password
+	bob := store.addUser(t, env, "bob", "", RoleMember)
+	bob.Digest = []byte{}
+
+	aliceOnly := store.link(alice, "alice")
+	bobFirst, bobLast := store.link(bob, "bob-1"), store.link(bob, "bob-2")
+
+	if code := deleteIdentity(env, alice, bobFirst); code != http.StatusNotFound {
+		t.Errorf("unlinking another user's identity returned %d, want 404", code)
+	}
+	if code := deleteIdentity(env, bob, bobFirst); code != http.StatusOK {
+		t.Errorf("unlinking one of two identities returned %d, want 200", code)
+	}
+	if code := deleteIdentity(env, bob, bobLast); code != http.StatusConflict {
+		t.Errorf("unlinking the last identity without a password returned %d, want 409", code)
+	}
+	if _, ok := store.identities[bobLast]; !ok {
+		t.Error("the last identity of a user without a password was unlinked")
+	}
+	if code := deleteIdentity(env, alice, aliceOnly); code != http.StatusOK {
+		t.Errorf("unlinking the last identity with a password returned %d, want 200", code)
+	}
+	if got := len(store.actions()); got != 2 {
+		t.Errorf("got %d audit events, want 2", got)
+	}
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/sdwalsh/mirango-go/mailer"
+	"github.com/sdwalsh/mirango-go/token"
+)
+
+// passwordResetTTL is how long a password reset link stays valid
+const passwordResetTTL = time.Hour
+
+// ForgotPassword takes an email from a form and mails a password reset link to the user
+// with that address. It always returns 202 before looking the address up so neither the
+// status nor the response time can be used to find registered emails
+func (env *Env) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	s := bluemonday.UGCPolicy()
+	email := s.Sanitize(r.FormValue("email"))
+	go env.mailPasswordReset(describe(r), email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// mailPasswordReset mails a password reset link to the user with the email address (if
+// there is one), errors are only logged for the described request (run as a goroutine)
+func (env *Env) mailPasswordReset(info requestInfo, email string) {
+	u, err := env.DB.GetUserByEmail(email)
+	if err != nil {
+		env.logRequest(info, err)
+		return
+	}
+	plain, hash, err := token.Opaque()
+	if err != nil {
+		env.logRequest(info, err)
+		return
+	}
+	_, err = env.DB.InsertPasswordReset(u.ID, hash, time.Now().Add(passwordResetTTL))
+	if err != nil {
+		env.logRequest(info, err)
+		return
+	}
+	env.mail(info, mailer.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: "Someone (hopefully you) asked to reset the password for " + u.Uname + ".\n\n" +
+			"Use this link within the next hour to choose a new password:\n\n" +
+			env.BaseURL + "/reset-password?token=" + plain + "\n\n" +
+			"If you did not ask for this you can ignore this email.\n",
+	})
+}
+
+// ResetPassword takes token, password and password2 from a form and replaces the user's
+// password, every existing session of the user is revoked
+func (env *Env) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	password := r.FormValue("password")
+	if password == "" || password != r.FormValue("password2") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	reset, err := env.DB.UsePasswordReset(token.Hash(r.FormValue("token")))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	digest, err := env.hashPassword(password)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_, err = env.DB.UpdateUserDigest(reset.UserID, digest)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_, err = env.DB.RevokeUserSessions(reset.UserID, uuid.Nil)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
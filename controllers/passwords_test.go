@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sdwalsh/mirango-go/mailer"
+)
+
+// forgotPassword posts the email to ForgotPassword and returns the status
+func forgotPassword(env *Env, email string) int {
+	form := url.Values{"email": {email}}
+	r := httptest.NewRequest("POST", "/auth/password/forgot", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	env.ForgotPassword(w, r)
+	return w.Code
+}
+
+func TestForgotPassword(t *testing.T) {
+	store := newFakeStore()
+	env := newTestEnv(t, store)
+	m := &blockingMailer{sent: make(chan mailer.Message)}
+	env.Mailer = m
+	store.addUser(t, env, "alice", "correct horse", RoleMember)
+
+	// Unknown addresses get the same answer and no mail
+	if code := forgotPassword(env, "bob@example.com"); code != http.StatusAccepted {
+		t.Errorf("forgot password for an unknown email returned %d, want 202", code)
+	}
+	// The mailer blocks until the message is received, the handler must not wait for it
+	if code := forgotPassword(env, "ALICE@example.com"); code != http.StatusAccepted {
+		t.Errorf("forgot password returned %d, want 202", code)
+	}
+	select {
+	case msg := <-m.sent:
+		if msg.To != "alice@example.com" || !strings.Contains(msg.Body, "/reset-password?token=") {
+			t.Errorf("unexpected reset mail %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reset mail was sent")
+	}
+	select {
+	case msg := <-m.sent:
+		t.Errorf("unexpected second mail %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
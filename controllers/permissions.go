@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+// Roles stored in users.role (user_role enum)
+const (
+	RoleAdmin       = "ADMIN"
+	RoleEditor      = "EDITOR"
+	RoleAuthor      = "AUTHOR"
+	RoleContributor = "CONTRIBUTOR"
+	RoleMember      = "MEMBER"
+)
+
+// Permission is an action a role may be allowed to take
+type Permission string
+
+// Permissions checked by Require and the post controllers
+const (
+	// PermPostCreate allows writing posts and editing or deleting your own
+	PermPostCreate Permission = "post:create"
+	// PermPostPublish allows publishing (and unpublishing) posts you are allowed to edit
+	PermPostPublish Permission = "post:publish"
+	// PermPostEditAny allows reading, editing and deleting every post including drafts
+	PermPostEditAny Permission = "post:edit_any"
+	// PermTagManage allows creating, renaming and deleting tags
+	PermTagManage Permission = "tag:manage"
+	// PermUserManage allows creating accounts and managing other users
+	PermUserManage Permission = "user:manage"
+	// PermAuditRead allows reading the audit log
+	PermAuditRead Permission = "audit:read"
+)
+
+// rolePermissions maps every role to the permissions it grants
+var rolePermissions = map[string][]Permission{
+	RoleAdmin:       {PermPostCreate, PermPostPublish, PermPostEditAny, PermTagManage, PermUserManage, PermAuditRead},
+	RoleEditor:      {PermPostCreate, PermPostPublish, PermPostEditAny, PermTagManage},
+	RoleAuthor:      {PermPostCreate, PermPostPublish},
+	RoleContributor: {PermPostCreate},
+	RoleMember:      {},
+}
+
+// validRole reports whether role is one of the user_role values
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// can reports whether the user's role grants the permission, nil users have no permissions
+func can(user *models.User, perm Permission) bool {
+	if user == nil {
+		return false
+	}
+	for _, p := range rolePermissions[user.Role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// canEditPost reports whether the user may edit or delete the post
+func canEditPost(user *models.User, p *models.Post) bool {
+	if can(user, PermPostEditAny) {
+		return true
+	}
+	return can(user, PermPostCreate) && p.UserID == user.ID
+}
+
+// Require blocks all requests unless the user's role grants every permission
+// assumes user is stored in context (run UserCtx before running this middleware)
+func (env *Env) Require(perms ...Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			user, ok := ctx.Value(contextUser).(*models.User)
+			// User was not found, invalid jwt, or not signed in
+			if !ok {
+				http.Error(w, http.StatusText(401), 401)
+				return
+			}
+			for _, perm := range perms {
+				if !can(user, perm) {
+					http.Error(w, http.StatusText(403), 403)
+					return
+				}
+			}
+			// Admins have to enroll in two factor first when the policy is enabled
+			if env.RequireAdmin2FA && user.Role == RoleAdmin && !user.TOTPEnabled {
+				http.Error(w, "two factor authentication required", 403)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+func TestCan(t *testing.T) {
+	tests := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{RoleAdmin, PermPostCreate, true},
+		{RoleAdmin, PermPostPublish, true},
+		{RoleAdmin, PermPostEditAny, true},
+		{RoleAdmin, PermTagManage, true},
+		{RoleAdmin, PermUserManage, true},
+		{RoleAdmin, PermAuditRead, true},
+
+		{RoleEditor, PermPostCreate, true},
+		{RoleEditor, PermPostPublish, true},
+		{RoleEditor, PermPostEditAny, true},
+		{RoleEditor, PermTagManage, true},
+		{RoleEditor, PermUserManage, false},
+		{RoleEditor, PermAuditRead, false},
+
+		{RoleAuthor, PermPostCreate, true},
+		{RoleAuthor, PermPostPublish, true},
+		{RoleAuthor, PermPostEditAny, false},
+		{RoleAuthor, PermTagManage, false},
+		{RoleAuthor, PermUserManage, false},
+		{RoleAuthor, PermAuditRead, false},
+
+		{RoleContributor, PermPostCreate, true},
+		{RoleContributor, PermPostPublish, false},
+		{RoleContributor, PermPostEditAny, false},
+		{RoleContributor, PermTagManage, false},
+		{RoleContributor, PermUserManage, false},
+		{RoleContributor, PermAuditRead, false},
+
+		{RoleMember, PermPostCreate, false},
+		{RoleMember, PermPostPublish, false},
+		{RoleMember, PermPostEditAny, false},
+		{RoleMember, PermTagManage, false},
+		{RoleMember, PermUserManage, false},
+		{RoleMember, PermAuditRead, false},
+
+		{"SUPERUSER", PermPostCreate, false},
+		{"", PermAuditRead, false},
+	}
+	for _, tt := range tests {
+		if got := can(&models.User{Role: tt.role}, tt.perm); got != tt.want {
+			t.Errorf("can(%s, %s) = %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+	if can(nil, PermPostCreate) {
+		t.Error("nil user has a permission")
+	}
+}
+
+func TestValidRole(t *testing.T) {
+	for _, role := range []string{RoleAdmin, RoleEditor, RoleAuthor, RoleContributor, RoleMember} {
+		if !validRole(role) {
+			t.Errorf("%s is not valid", role)
+		}
+	}
+	for _, role := range []string{"", "admin", "SUPERUSER"} {
+		if validRole(role) {
+			t.Errorf("%q is valid", role)
+		}
+	}
+}
+
+func TestCanEditPost(t *testing.T) {
+	owner := uuid.New()
+	post := &models.Post{UserID: owner}
+	tests := []struct {
+		name string
+		user *models.User
+		want bool
+	}{
+		{"admin", &models.User{ID: uuid.New(), Role: RoleAdmin}, true},
+		{"editor", &models.User{ID: uuid.New(), Role: RoleEditor}, true},
+		{"author owning the post", &models.User{ID: owner, Role: RoleAuthor}, true},
+		{"other author", &models.User{ID: uuid.New(), Role: RoleAuthor}, false},
+		{"contributor owning the post", &models.User{ID: owner, Role: RoleContributor}, true},
+		{"other contributor", &models.User{ID: uuid.New(), Role: RoleContributor}, false},
+		// Demoted users lose access to the posts they wrote
+		{"member owning the post", &models.User{ID: owner, Role: RoleMember}, false},
+	}
+	for _, tt := range tests {
+		if got := canEditPost(tt.user, post); got != tt.want {
+			t.Errorf("%s: canEditPost = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRequire(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       *models.User
+		perms      []Permission
+		require2FA bool
+		want       int
+	}{
+		{"not signed in", nil, []Permission{PermPostCreate}, false, http.StatusUnauthorized},
+		{"granted", &models.User{Role: RoleAuthor}, []Permission{PermPostCreate}, false, http.StatusOK},
+		{"every permission granted", &models.User{Role: RoleEditor}, []Permission{PermPostCreate, PermPostEditAny}, false, http.StatusOK},
+		{"one permission missing", &models.User{Role: RoleAuthor}, []Permission{PermPostCreate, PermPostEditAny}, false, http.StatusForbidden},
+		{"member", &models.User{Role: RoleMember}, []Permission{PermPostCreate}, false, http.StatusForbidden},
+		{"no permissions required", &models.User{Role: RoleMember}, nil, false, http.StatusOK},
+		{"admin without two factor", &models.User{Role: RoleAdmin}, []Permission{PermUserManage}, false, http.StatusOK},
+		{"admin without two factor required", &models.User{Role: RoleAdmin}, []Permission{PermUserManage}, true, http.StatusForbidden},
+		{"admin with two factor required", &models.User{Role: RoleAdmin, TOTPEnabled: true}, []Permission{PermUserManage}, true, http.StatusOK},
+		{"editor without two factor required", &models.User{Role: RoleEditor}, []Permission{PermPostEditAny}, true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		env := &Env{RequireAdmin2FA: tt.require2FA, Sugar: zap.NewNop().Sugar()}
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), contextUser, tt.user))
+		}
+		w := httptest.NewRecorder()
+		env.Require(tt.perms...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})).ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  *models.APIToken
+		scope  string
+		status int
+	}{
+		{"browser session", nil, ScopePostsWrite, http.StatusOK},
+		{"granted scope", &models.APIToken{Scopes: []string{ScopePostsRead, ScopePostsWrite}}, ScopePostsWrite, http.StatusOK},
+		{"missing scope", &models.APIToken{Scopes: []string{ScopePostsRead}}, ScopePostsWrite, http.StatusForbidden},
+		{"no scopes", &models.APIToken{}, ScopePostsRead, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		env := &Env{Sugar: zap.NewNop().Sugar()}
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.token != nil {
+			r = r.WithContext(context.WithValue(r.Context(), contextAPIToken, tt.token))
+		}
+		w := httptest.NewRecorder()
+		env.RequireScope(tt.scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})).ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
@@ -1,18 +1,114 @@
 package controllers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/google/uuid"
 	"github.com/microcosm-cc/bluemonday"
 	"github.com/sdwalsh/mirango-go/models"
+	"github.com/sdwalsh/mirango-go/render"
+	"github.com/sdwalsh/mirango-go/scheduler"
+	"github.com/sdwalsh/mirango-go/slug"
 )
 
+// slugAttempts is how many numbered alternatives are tried for a generated slug
+const slugAttempts = 100
+
+// slugTaken reports whether the slug belongs to (or redirects to) another post than p
+func (env *Env) slugTaken(s string, p *models.Post) (bool, error) {
+	other, err := env.DB.FindPostBySlug(s)
+	if err == sql.ErrNoRows {
+		other, err = env.DB.FindSlugRedirect(s)
+	}
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return p == nil || other.ID != p.ID, nil
+}
+
+// postSlug returns the slug for a new post or an update of p and writes an error response
+// if there is none. A requested slug is normalized and has to be free, without one the
+// slug of p is kept or a free one is generated from the title
+func (env *Env) postSlug(w http.ResponseWriter, r *http.Request, requested string, title string, p *models.Post) (string, bool) {
+	if requested == "" && p != nil {
+		return p.Slug, true
+	}
+	if requested != "" {
+		s := slug.Make(requested)
+		taken, err := env.slugTaken(s, p)
+		if err != nil {
+			env.log(r, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return "", false
+		}
+		if s == "" || taken {
+			w.WriteHeader(http.StatusConflict)
+			return "", false
+		}
+		return s, true
+	}
+	base := slug.Make(title)
+	if base == "" {
+		base = "post"
+	}
+	for n := 1; n <= slugAttempts; n++ {
+		s := base
+		if n > 1 {
+			s = slug.Suffix(base, n)
+		}
+		taken, err := env.slugTaken(s, p)
+		if err != nil {
+			env.log(r, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return "", false
+		}
+		if !taken {
+			return s, true
+		}
+	}
+	w.WriteHeader(http.StatusConflict)
+	return "", false
+}
+
+// formPublishAt parses the RFC3339 publish_at form field and writes an error response if
+// the user may not schedule the post. present is false when the form has no publish_at
+// field, a blank one cancels the schedule
+func (env *Env) formPublishAt(w http.ResponseWriter, r *http.Request, user *models.User, published bool) (at *time.Time, present bool, ok bool) {
+	r.ParseForm()
+	values, present := r.Form["publish_at"]
+	if !present {
+		return nil, false, true
+	}
+	if !can(user, PermPostPublish) {
+		w.WriteHeader(http.StatusForbidden)
+		return nil, true, false
+	}
+	if values[0] == "" {
+		return nil, true, true
+	}
+	t, err := time.Parse(time.RFC3339, values[0])
+	// Published posts cannot be scheduled
+	if err != nil || published {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, true, false
+	}
+	return &t, true, true
+}
+
 // CreatePost takes form data and inserts a post into the database
-// expects the user and role to be in the request context.
+// expects the user and role to be in the request context. A blank slug is generated from the title
+// and drafts with a publish_at are published by the scheduler. content is written in
+// source_format (html by default or markdown) and rendered to HTML
 func (env *Env) CreatePost(w http.ResponseWriter, r *http.Request) {
 	// Grab the context to get the user
 	ctx := r.Context()
@@ -20,10 +116,13 @@ func (env *Env) CreatePost(w http.ResponseWriter, r *http.Request) {
 	s := bluemonday.UGCPolicy()
 	user := ctx.Value(contextUser).(*models.User)
 	title := s.Sanitize(r.FormValue("title"))
-	slug := s.Sanitize(r.FormValue("slug"))
+	// The slug is made from the raw input, sanitizing would turn & into amp
+	slug := r.FormValue("slug")
 	subtitle := s.Sanitize(r.FormValue("subtitle"))
 	short := s.Sanitize(r.FormValue("short"))
-	content := s.Sanitize(r.FormValue("content"))
+	// content is the source, only the HTML rendered from it is sanitized
+	source := r.FormValue("content")
+	format := r.FormValue("source_format")
 	digest := s.Sanitize(r.FormValue("digest"))
 	// published must be parsed into a bool
 	published, err := strconv.ParseBool(s.Sanitize(r.FormValue("published")))
@@ -32,24 +131,81 @@ func (env *Env) CreatePost(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	p, err := env.DB.InsertPost(user.ID, title, slug, subtitle, short, content, digest, published)
+	// Contributors can only submit drafts
+	if published && !can(user, PermPostPublish) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	// tags holds the slugs of existing tags to attach
+	tags, _, err := env.formTags(r)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if format == "" {
+		format = render.HTML
+	}
+	content, err := render.Render(format, source)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	at, _, ok := env.formPublishAt(w, r, user, published)
+	if !ok {
+		return
+	}
+	slug, ok = env.postSlug(w, r, slug, r.FormValue("title"), nil)
+	if !ok {
+		return
+	}
+	p, err := env.DB.InsertPost(user.ID, &models.PostFields{
+		Title:        title,
+		Slug:         slug,
+		SubTitle:     subtitle,
+		Short:        short,
+		SourceFormat: format,
+		Source:       source,
+		PostContent:  content,
+		Digest:       digest,
+		Published:    published,
+		Tags:         tags,
+		PublishAt:    at,
+	})
+	if uniqueViolation(err) {
+		// Another post took the slug after postSlug checked it
+		env.log(r, err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	tp, err := env.withTags(p)
 	if err != nil {
 		env.log(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	env.audit(r, user, ActionPostCreate, targetPost, &p.ID, nil, tp)
+	if p.Published {
+		scheduler.Fire(env.PublishHooks, p)
+	}
 	// Send out created post
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(p)
+	json.NewEncoder(w).Encode(tp)
 }
 
 // GetPost if ID matches a post return a json post. If the post is unpublished
-// check if user is an admin otherwise return 404
+// check if user is allowed to edit it otherwise return 404
 func (env *Env) GetPost(w http.ResponseWriter, r *http.Request) {
-	// Grab the context to get the user
+	// Grab the context to get the user (nil if not signed in)
 	ctx := r.Context()
-	user := ctx.Value(contextUser).(*models.User)
+	user, _ := ctx.Value(contextUser).(*models.User)
 	id, err := uuid.Parse(chi.URLParam(r, "postID"))
 	if err != nil {
 		env.log(r, err)
@@ -62,12 +218,47 @@ func (env *Env) GetPost(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	if p.Published == false && user.Role != "ADMIN" {
+	env.writePost(w, r, user, p)
+}
+
+// GetPostBySlug returns the post with the slug url param like GetPost, slugs the post
+// used to have redirect to its current slug with a 301
+func (env *Env) GetPostBySlug(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, _ := ctx.Value(contextUser).(*models.User)
+	s := chi.URLParam(r, "slug")
+	p, err := env.DB.FindPostBySlug(s)
+	if err == sql.ErrNoRows {
+		p, err = env.DB.FindSlugRedirect(s)
+		if err == nil && (p.Published || canEditPost(user, p)) {
+			http.Redirect(w, r, "/posts/by-slug/"+url.PathEscape(p.Slug), http.StatusMovedPermanently)
+			return
+		}
+	}
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	env.writePost(w, r, user, p)
+}
+
+// writePost sends the post with its tags, unpublished posts are only shown to users
+// allowed to edit them
+func (env *Env) writePost(w http.ResponseWriter, r *http.Request, user *models.User, p *models.Post) {
+	if p.Published == false && !canEditPost(user, p) {
 		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	tp, err := env.withTags(p)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(p)
+	json.NewEncoder(w).Encode(tp)
 }
 
 // GetPosts is an admin only function that returns posts
@@ -113,15 +304,18 @@ func (env *Env) GetPublishedPosts(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(p)
 }
 
-// GetUnpublishedPosts returns all published / public posts
+// GetUnpublishedPosts returns all unpublished posts, users who cannot edit every post
+// only see their own drafts
 func (env *Env) GetUnpublishedPosts(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	user := ctx.Value(contextUser).(*models.User)
-	// Just a double check - should we remove?
-	if user.Role != "ADMIN" {
-		w.WriteHeader(http.StatusForbidden)
+	var p *[]models.Post
+	var err error
+	if can(user, PermPostEditAny) {
+		p, err = env.DB.UnpublishedPosts()
+	} else {
+		p, err = env.DB.UnpublishedPostsByUser(user.ID)
 	}
-	p, err := env.DB.UnpublishedPosts()
 	if err != nil {
 		env.log(r, err)
 		w.WriteHeader(http.StatusNotFound)
@@ -132,23 +326,49 @@ func (env *Env) GetUnpublishedPosts(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(p)
 }
 
+// findEditablePost loads the post from the postID url param and writes an error
+// response if it does not exist or the user is not allowed to change it
+func (env *Env) findEditablePost(w http.ResponseWriter, r *http.Request, user *models.User) (*models.Post, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "postID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	p, err := env.DB.FindPost(id)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+	// Only the author can change a post unless the user can edit any post
+	if !canEditPost(user, p) {
+		w.WriteHeader(http.StatusForbidden)
+		return nil, false
+	}
+	return p, true
+}
+
 // UpdatePost takes form data and a post ID to update stored information
+// the author is kept and changing the published state requires post:publish,
+// a blank slug or source_format keeps the current one and the tags and schedule (publish_at)
+// are only replaced when the form has the field
 func (env *Env) UpdatePost(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	s := bluemonday.UGCPolicy()
 	user := ctx.Value(contextUser).(*models.User)
-	// post ID needs to be sanitized and parsed - return if error parsing
-	id, err := uuid.Parse(s.Sanitize(r.FormValue("id")))
-	if err != nil {
-		env.log(r, err)
-		w.WriteHeader(http.StatusBadRequest)
+	post, ok := env.findEditablePost(w, r, user)
+	if !ok {
 		return
 	}
 	title := s.Sanitize(r.FormValue("title"))
-	slug := s.Sanitize(r.FormValue("slug"))
+	// The slug is made from the raw input, sanitizing would turn & into amp
+	slug := r.FormValue("slug")
 	subtitle := s.Sanitize(r.FormValue("subtitle"))
 	short := s.Sanitize(r.FormValue("short"))
-	content := s.Sanitize(r.FormValue("content"))
+	// content is the source, only the HTML rendered from it is sanitized
+	source := r.FormValue("content")
+	format := r.FormValue("source_format")
 	digest := s.Sanitize(r.FormValue("digest"))
 	// published must be parsed into a bool
 	published, err := strconv.ParseBool(s.Sanitize(r.FormValue("published")))
@@ -157,35 +377,106 @@ func (env *Env) UpdatePost(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	p, err := env.DB.UpdatePost(id, user.ID, title, slug, subtitle, short, content, digest, published)
+	if published != post.Published && !can(user, PermPostPublish) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	tags, retag, err := env.formTags(r)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if format == "" {
+		format = post.SourceFormat
+	}
+	content, err := render.Render(format, source)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	at, reschedule, ok := env.formPublishAt(w, r, user, published)
+	if !ok {
+		return
+	}
+	slug, ok = env.postSlug(w, r, slug, r.FormValue("title"), post)
+	if !ok {
+		return
+	}
+	before, err := env.withTags(post)
 	if err != nil {
 		env.log(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	p, err := env.DB.UpdatePost(post.ID, &models.PostFields{
+		Title:        title,
+		Slug:         slug,
+		SubTitle:     subtitle,
+		Short:        short,
+		SourceFormat: format,
+		Source:       source,
+		PostContent:  content,
+		Digest:       digest,
+		Published:    published,
+		Tags:         tags,
+		KeepTags:     !retag,
+		PublishAt:    at,
+		KeepSchedule: !reschedule,
+	})
+	if uniqueViolation(err) {
+		// Another post took the slug after postSlug checked it
+		env.log(r, err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	env.pruneRevisions(r, p)
+	tp, err := env.withTags(p)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	action := ActionPostUpdate
+	if p.Published && !post.Published {
+		action = ActionPostPublish
+	} else if !p.Published && post.Published {
+		action = ActionPostUnpublish
+	} else if reschedule {
+		action = ActionPostSchedule
+	}
+	env.audit(r, user, action, targetPost, &p.ID, before, tp)
+	if action == ActionPostPublish {
+		scheduler.Fire(env.PublishHooks, p)
+	}
 	// Send out updated post
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(p)
+	json.NewEncoder(w).Encode(tp)
 }
 
-// DeletePost removes a post from the database if the user is the owner
+// DeletePost removes a post from the database if the user is the owner or can edit any post
 func (env *Env) DeletePost(w http.ResponseWriter, r *http.Request) {
 	// Grab the context to get the user
 	ctx := r.Context()
 	user := ctx.Value(contextUser).(*models.User)
-	id, err := uuid.Parse(chi.URLParam(r, "postID"))
-	if err != nil {
-		env.log(r, err)
-		w.WriteHeader(http.StatusBadRequest)
+	post, ok := env.findEditablePost(w, r, user)
+	if !ok {
 		return
 	}
 	// Ignored returned post since it was deleted
-	_, err = env.DB.DeletePost(id, user.ID)
+	_, err := env.DB.DeletePost(post.ID)
 	if err != nil {
 		env.log(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	env.audit(r, user, ActionPostDelete, targetPost, &post.ID, post, nil)
 	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/sdwalsh/mirango-go/ratelimit"
+)
+
+// RateLimit returns middleware that rejects requests with 429 once the client's ip_root
+// has made rule.Limit attempts within rule.Window. Requests without a valid client address
+// are rejected with 400, when the limiter fails the fallback limiter counts the attempt
+// and without one (or if it fails as well) the request is rejected with 503.
+func (env *Env) RateLimit(rule ratelimit.Rule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := remoteIP(r)
+			wait, err := ratelimit.Check(env.Limiter, rule, ip)
+			if err == ratelimit.ErrIP {
+				env.log(r, err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			if err != nil && env.FallbackLimiter != nil {
+				env.log(r, err)
+				wait, err = ratelimit.Check(env.FallbackLimiter, rule, ip)
+			}
+			if err != nil {
+				// Fail closed so an unavailable limiter cannot be used to brute force
+				env.log(r, err)
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+			if wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/sdwalsh/mirango-go/ratelimit"
+)
+
+// failingLimiter is a limiter whose storage is unavailable
+type failingLimiter struct{}
+
+var errUnavailable = errors.New("limiter unavailable")
+
+func (failingLimiter) RecordAttempt(bucket string, ip string) error { return errUnavailable }
+func (failingLimiter) CountAttempts(bucket string, ip string, since time.Time) (int, time.Time, error) {
+	return 0, time.Time{}, errUnavailable
+}
+func (failingLimiter) PruneAttempts(before time.Time) (int64, error) { return 0, errUnavailable }
+
+var testRule = ratelimit.Rule{Bucket: "login", Limit: 2, Window: time.Minute}
+
+// limited sends a request from the remote address through the rate limit and returns the
+// status code
+func limited(env *Env, remoteAddr string) int {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	env.RateLimit(testRule)(ok).ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestRateLimit(t *testing.T) {
+	env := &Env{Limiter: ratelimit.NewMemory(), Sugar: zap.NewNop().Sugar()}
+	for i := 0; i < testRule.Limit; i++ {
+		if code := limited(env, "192.0.2.1:1234"); code != http.StatusOK {
+			t.Fatalf("attempt %d returned %d", i+1, code)
+		}
+	}
+	if code := limited(env, "192.0.2.9:4321"); code != http.StatusTooManyRequests {
+		t.Errorf("attempt over the limit returned %d, want 429", code)
+	}
+	if code := limited(env, "[2001:db8::1]:1234"); code != http.StatusOK {
+		t.Errorf("other network returned %d", code)
+	}
+	for _, addr := range []string{"", "unknown", "unknown:1234"} {
+		if code := limited(env, addr); code != http.StatusBadRequest {
+			t.Errorf("remote address %q returned %d, want 400", addr, code)
+		}
+	}
+}
+
+func TestRateLimitStoreFailure(t *testing.T) {
+	// Without a fallback the limiter fails closed
+	env := &Env{Limiter: failingLimiter{}, Sugar: zap.NewNop().Sugar()}
+	if code := limited(env, "192.0.2.1:1234"); code != http.StatusServiceUnavailable {
+		t.Errorf("failing limiter returned %d, want 503", code)
+	}
+	env.FallbackLimiter = failingLimiter{}
+	if code := limited(env, "192.0.2.1:1234"); code != http.StatusServiceUnavailable {
+		t.Errorf("failing fallback returned %d, want 503", code)
+	}
+
+	// The fallback keeps counting while the limiter is down
+	env.FallbackLimiter = ratelimit.NewMemory()
+	for i := 0; i < testRule.Limit; i++ {
+		if code := limited(env, "192.0.2.1:1234"); code != http.StatusOK {
+			t.Fatalf("attempt %d returned %d", i+1, code)
+		}
+	}
+	if code := limited(env, "192.0.2.1:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("attempt over the fallback limit returned %d, want 429", code)
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	proxies, err := ParseProxies([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded string
+		realIP    string
+		want      string
+	}{
+		{"direct client", "192.0.2.1:1234", "", "", "192.0.2.1:1234"},
+		{"spoofed by an untrusted peer", "192.0.2.1:1234", "198.51.100.7", "198.51.100.8", "192.0.2.1:1234"},
+		{"trusted proxy", "10.0.0.1:1234", "198.51.100.7", "", "198.51.100.7"},
+		{"trusted ipv6 proxy", "[2001:db8::1]:1234", "198.51.100.7", "", "198.51.100.7"},
+		{"client prepends a fake hop", "10.0.0.1:1234", "203.0.113.5, 198.51.100.7", "", "198.51.100.7"},
+		{"chain of trusted proxies", "10.0.0.1:1234", "198.51.100.7, 10.0.0.2, 10.0.0.3", "", "198.51.100.7"},
+		{"only trusted hops", "10.0.0.1:1234", "10.0.0.2, 10.0.0.3", "", "10.0.0.2"},
+		{"x-real-ip", "10.0.0.1:1234", "", "198.51.100.8", "198.51.100.8"},
+		{"invalid forwarded hop", "10.0.0.1:1234", "198.51.100.7, garbage", "", "10.0.0.1:1234"},
+		{"invalid x-real-ip", "10.0.0.1:1234", "", "garbage", "10.0.0.1:1234"},
+	}
+	for _, tt := range tests {
+		var got string
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.peer
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		RealIP(proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.RemoteAddr
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		if got != tt.want {
+			t.Errorf("%s: remote address %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	if _, err := ParseProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("accepted an invalid network")
+	}
+	if _, err := ParseProxies([]string{"proxy.example.com"}); err == nil {
+		t.Error("accepted a host name")
+	}
+	nets, err := ParseProxies([]string{" 192.0.2.1 ", ""})
+	if err != nil || len(nets) != 1 || nets[0].String() != "192.0.2.1/32" {
+		t.Errorf("got %v, %v", nets, err)
+	}
+}
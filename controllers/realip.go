@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseProxies parses the addresses (192.0.2.1) and networks (10.0.0.0/8) of the trusted
+// reverse proxies
+func ParseProxies(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: v}
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trusted returns true if the ip belongs to one of the networks
+func trusted(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RealIP returns middleware that replaces the request's remote address with the client
+// address from X-Forwarded-For or X-Real-IP, but only when the request came from one of the
+// trusted proxies. X-Forwarded-For is read from the right so clients cannot choose their
+// address by sending the header themselves, the first address that is not a trusted proxy
+// is the client.
+func RealIP(proxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer := net.ParseIP(remoteIP(r))
+			if peer == nil || !trusted(proxies, peer) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if client := forwardedFor(proxies, r.Header.Get("X-Forwarded-For")); client != nil {
+				r.RemoteAddr = client.String()
+			} else if client := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); client != nil {
+				r.RemoteAddr = client.String()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedFor returns the right most address of the X-Forwarded-For header that is not a
+// trusted proxy (the left most one when every hop is trusted), nil if an address does not
+// parse or the header is empty
+func forwardedFor(proxies []*net.IPNet, header string) net.IP {
+	if header == "" {
+		return nil
+	}
+	hops := strings.Split(header, ",")
+	var client net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		client = net.ParseIP(strings.TrimSpace(hops[i]))
+		if client == nil {
+			return nil
+		}
+		if !trusted(proxies, client) {
+			break
+		}
+	}
+	return client
+}
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/sdwalsh/mirango-go/diff"
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+// diffContext is the number of unchanged lines shown around changes in unified diffs
+const diffContext = 3
+
+// pruneRevisions applies the retention limits to the post's revisions, failures are only logged
+func (env *Env) pruneRevisions(r *http.Request, p *models.Post) {
+	var before time.Time
+	if env.RevisionMaxAge > 0 {
+		before = time.Now().Add(-env.RevisionMaxAge)
+	}
+	_, err := env.DB.PrunePostRevisions(p.ID, env.RevisionLimit, before)
+	if err != nil {
+		env.log(r, err)
+	}
+}
+
+// findRevision loads the numbered revision of the post and writes an error response if
+// it does not exist
+func (env *Env) findRevision(w http.ResponseWriter, r *http.Request, p *models.Post, number string) (*models.PostRevision, bool) {
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	rev, err := env.DB.FindPostRevision(p.ID, n)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+	return rev, true
+}
+
+// revisionText is the text of a version of a post that is diffed, the source rather than
+// the rendered HTML
+func revisionText(title string, subtitle string, short string, content string) string {
+	return fmt.Sprintf("Title: %s\nSubtitle: %s\nShort: %s\n\n%s", title, subtitle, short, content)
+}
+
+// GetPostRevisions returns the revisions of the post in the postID url param, newest first
+func (env *Env) GetPostRevisions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	post, ok := env.findEditablePost(w, r, user)
+	if !ok {
+		return
+	}
+	revs, err := env.DB.GetPostRevisions(post.ID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(revs)
+}
+
+// GetPostRevision returns the revision in the revision url param
+func (env *Env) GetPostRevision(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	post, ok := env.findEditablePost(w, r, user)
+	if !ok {
+		return
+	}
+	rev, ok := env.findRevision(w, r, post, chi.URLParam(r, "revision"))
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rev)
+}
+
+// DiffPostRevisions compares the revision in the from query string with the one in to
+// (the current post when to is not given). format=unified (the default) returns a
+// text/plain unified diff of the lines, format=words returns json edits of the words
+func (env *Env) DiffPostRevisions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	post, ok := env.findEditablePost(w, r, user)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	from, ok := env.findRevision(w, r, post, q.Get("from"))
+	if !ok {
+		return
+	}
+	fromName := fmt.Sprintf("revision %d", from.Revision)
+	a := revisionText(from.Title, from.SubTitle, from.Short, from.Source)
+	toName := "current"
+	b := revisionText(post.Title, post.SubTitle, post.Short, post.Source)
+	if q.Get("to") != "" {
+		to, ok := env.findRevision(w, r, post, q.Get("to"))
+		if !ok {
+			return
+		}
+		toName = fmt.Sprintf("revision %d", to.Revision)
+		b = revisionText(to.Title, to.SubTitle, to.Short, to.Source)
+	}
+	switch q.Get("format") {
+	case "", "unified":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(diff.Unified(fromName, toName, diff.Lines(a), diff.Lines(b), diffContext)))
+	case "words":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(diff.Compact(diff.Diff(diff.Words(a), diff.Words(b))))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// RestorePostRevision replaces the post's content with the revision in the revision url
+// param, the replaced version becomes a new revision. The published state is kept and
+// the revision's slug has to be free
+func (env *Env) RestorePostRevision(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	post, ok := env.findEditablePost(w, r, user)
+	if !ok {
+		return
+	}
+	rev, ok := env.findRevision(w, r, post, chi.URLParam(r, "revision"))
+	if !ok {
+		return
+	}
+	slug, ok := env.postSlug(w, r, rev.Slug, rev.Title, post)
+	if !ok {
+		return
+	}
+	// Revisions do not record tags, the post keeps its current ones
+	p, err := env.DB.UpdatePost(post.ID, &models.PostFields{
+		Title:        rev.Title,
+		Slug:         slug,
+		SubTitle:     rev.SubTitle,
+		Short:        rev.Short,
+		SourceFormat: rev.SourceFormat,
+		Source:       rev.Source,
+		PostContent:  rev.PostContent,
+		Digest:       rev.Digest,
+		Published:    post.Published,
+		KeepTags:     true,
+		KeepSchedule: true,
+	})
+	if uniqueViolation(err) {
+		// Another post took the slug after postSlug checked it
+		env.log(r, err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	env.pruneRevisions(r, p)
+	tp, err := env.withTags(p)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	env.audit(r, user, ActionPostRestore, targetPost, &p.ID, post, p)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tp)
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+// maxSearchResults is the most results Search returns per request
+const maxSearchResults = 50
+
+// Search returns the posts matching the q query string (websearch syntax: "quoted phrases",
+// or, -excluded) best match first with highlighted snippets. tag filters by tag slug and
+// published (true or false) by state, drafts are only searched by users who can edit every
+// post. Query string s and e define which rows to query s defaults to 0 and e to s + 10,
+// at most maxSearchResults rows are returned
+func (env *Env) Search(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, _ := ctx.Value(contextUser).(*models.User)
+	q := r.URL.Query()
+	f := models.SearchFilter{Query: q.Get("q"), Tag: q.Get("tag")}
+	if f.Query == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("published"); v != "" {
+		published, err := strconv.ParseBool(v)
+		if err != nil {
+			env.log(r, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.Published = &published
+	}
+	if !can(user, PermPostEditAny) {
+		if f.Published != nil && !*f.Published {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		published := true
+		f.Published = &published
+	}
+	start, end, ok := pageRange(w, r, 10)
+	if !ok {
+		return
+	}
+	// Anyone can search, every result runs ts_headline so pages are kept small
+	if end-start > maxSearchResults {
+		end = start + maxSearchResults
+	}
+	f.Start, f.End = start, end
+	results, err := env.DB.SearchPosts(f)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(results)
+}
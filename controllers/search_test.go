@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSearchRange(t *testing.T) {
+	tests := []struct {
+		query      string
+		code       int
+		start, end int
+	}{
+		{"q=go", http.StatusOK, 0, 10},
+		{"q=go&s=20", http.StatusOK, 20, 30},
+		{"q=go&s=5&e=15", http.StatusOK, 5, 15},
+		{"q=go&e=1000000", http.StatusOK, 0, maxSearchResults},
+		{"q=go&s=100&e=1000000", http.StatusOK, 100, 100 + maxSearchResults},
+		{"q=go&s=-1", http.StatusBadRequest, 0, 0},
+		{"q=go&s=10&e=5", http.StatusBadRequest, 0, 0},
+		{"s=0&e=10", http.StatusBadRequest, 0, 0},
+	}
+	for _, tt := range tests {
+		store := newFakeStore()
+		env := newTestEnv(t, store)
+		w := httptest.NewRecorder()
+		env.Search(w, httptest.NewRequest("GET", "/search?"+tt.query, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s: got %d, want %d", tt.query, w.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			if store.search != nil {
+				t.Errorf("%s: searched after rejecting the request", tt.query)
+			}
+			continue
+		}
+		f := store.search
+		if f.Start != tt.start || f.End != tt.end {
+			t.Errorf("%s: searched rows %d to %d, want %d to %d", tt.query, f.Start, f.End, tt.start, tt.end)
+		}
+		if f.Published == nil || !*f.Published {
+			t.Errorf("%s: anonymous search included drafts", tt.query)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+// sessionView adds whether the session is the one making the request
+type sessionView struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+// writeSessions sends out the sessions marking the one used for the request
+func writeSessions(w http.ResponseWriter, r *http.Request, sessions *[]models.Session) {
+	current, _ := r.Context().Value(contextSession).(*models.Session)
+	views := make([]sessionView, 0, len(*sessions))
+	for _, s := range *sessions {
+		views = append(views, sessionView{s, current != nil && current.ID == s.ID})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(views)
+}
+
+// GetSessions returns every active session of the signed in user
+func (env *Env) GetSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	sessions, err := env.DB.GetActiveSessionsByUser(user.ID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeSessions(w, r, sessions)
+}
+
+// DeleteSession revokes one of the signed in user's sessions
+func (env *Env) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	id, err := uuid.Parse(chi.URLParam(r, "sessionID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// Sessions belonging to someone else are reported as not found
+	_, err = env.DB.RevokeUserSession(id, user.ID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteOtherSessions logs the signed in user out everywhere except the current session
+func (env *Env) DeleteOtherSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	session := ctx.Value(contextSession).(*models.Session)
+	sessions, err := env.DB.RevokeUserSessions(user.ID, session.ID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeSessions(w, r, sessions)
+}
+
+// GetUserSessions is an admin only function that returns every active session of a user
+func (env *Env) GetUserSessions(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sessions, err := env.DB.GetActiveSessionsByUser(id)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeSessions(w, r, sessions)
+}
+
+// DeleteUserSession is an admin only function that revokes a single session of a user
+func (env *Env) DeleteUserSession(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id, err := uuid.Parse(chi.URLParam(r, "sessionID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	_, err = env.DB.RevokeUserSession(id, userID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	admin, _ := r.Context().Value(contextUser).(*models.User)
+	env.audit(r, admin, ActionSessionsRevoke, targetUser, &userID, nil, nil)
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteUserSessions is an admin only function that revokes every session of a user
+// (use it to lock out a compromised account)
+func (env *Env) DeleteUserSessions(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sessions, err := env.DB.RevokeUserSessions(id, uuid.Nil)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	admin, _ := r.Context().Value(contextUser).(*models.User)
+	env.audit(r, admin, ActionSessionsRevoke, targetUser, &id, nil, nil)
+	writeSessions(w, r, sessions)
+}
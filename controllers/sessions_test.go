@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+// login posts the credentials to Login and returns the response
+func login(env *Env, uname string, pass string) *httptest.ResponseRecorder {
+	form := url.Values{"user": {uname}, "password": {pass}}
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("User-Agent", "session-test")
+	w := httptest.NewRecorder()
+	env.Login(w, r)
+	return w
+}
+
+// cookie returns the named cookie set by the response
+func cookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// whoami runs a request with the cookie through UserCtx and returns the user and session
+// it found (nil when not signed in)
+func whoami(env *Env, c *http.Cookie) (*models.User, *models.Session) {
+	var user *models.User
+	var session *models.Session
+	r := httptest.NewRequest("GET", "/", nil)
+	if c != nil {
+		r.AddCookie(c)
+	}
+	env.UserCtx(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ = r.Context().Value(contextUser).(*models.User)
+		session, _ = r.Context().Value(contextSession).(*models.Session)
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	return user, session
+}
+
+// sessionOf returns the id of the session the authentication cookie belongs to
+func sessionOf(t *testing.T, env *Env, c *http.Cookie) uuid.UUID {
+	t.Helper()
+	claims, err := env.getUser(c.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := uuid.Parse(claims.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestLoginStartsSession(t *testing.T) {
+	store := newFakeStore()
+	env := newTestEnv(t, store)
+	u := store.addUser(t, env, "alice", "correct horse", RoleMember)
+
+	w := login(env, "alice", "correct horse")
+	if w.Code != http.StatusOK {
+		t.Fatalf("login returned %d", w.Code)
+	}
+	auth, refresh := cookie(w, "authentication"), cookie(w, "refresh")
+	if auth == nil || refresh == nil {
+		t.Fatal("login did not set the authentication and refresh cookies")
+	}
+	if len(store.sessions) != 1 {
+		t.Fatalf("got %d sessions, want 1", len(store.sessions))
+	}
+	for _, s := range store.sessions {
+		if s.UserID != u.ID || s.UserAgent != "session-test" || s.IPAddress != "192.0.2.1" {
+			t.Errorf("unexpected session %+v", s)
+		}
+	}
+
+	user, session := whoami(env, auth)
+	if user == nil || user.ID != u.ID {
+		t.Fatalf("UserCtx did not sign in the user, got %v", user)
+	}
+	if session == nil || store.sessions[session.ID] == nil {
+		t.Fatalf("UserCtx did not load the session, got %v", session)
+	}
+}
+
+func TestLoginWrongPassword(t *testing.T) {
+	store := newFakeStore()
+	env := newTestEnv(t, store)
+	u := store.addUser(t, env, "alice", "correct horse", RoleMember)
+
+	w := login(env, "alice", "battery staple")
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("login returned %d, want 401", w.Code)
+	}
+	if cookie(w, "authentication") != nil || len(store.sessions) != 0 {
+		t.Error("a failed login started a session")
+	}
+	if got := store.users[u.ID].FailedLogins; got != 1 {
+		t.Errorf("failed logins = %d, want 1", got)
+	}
+	if w := login(env, "bob", "correct horse"); w.Code != http.StatusNotFound {
+		t.Errorf("login for an unknown user returned %d, want 404", w.Code)
+	}
+}
+
+func TestUserCtxRejectsInactiveSessions(t *testing.T) {
+	store := newFakeStore()
+	env := newTestEnv(t, store)
+	u := store.addUser(t, env, "alice", "correct horse", RoleMember)
+	auth := cookie(login(env, "alice", "correct horse"), "authentication")
+
+	if user, _ := whoami(env, nil); user != nil {
+		t.Error("signed in without a cookie")
+	}
+	if user, _ := whoami(env, &http.Cookie{Name: "authentication", Value: auth.Value + "x"}); user != nil {
+		t.Error("signed in with a tampered token")
+	}
+
+	// The token stays valid but the session behind it is gone
+	for _, s := range store.sessions {
+		store.RevokeSession(s.ID)
+	}
+	if user, _ := whoami(env, auth); user != nil {
+		t.Error("signed in with a revoked session")
+	}
+
+	// Disabled users are signed out of sessions that are still active
+	auth = cookie(login(env, "alice", "correct horse"), "authentication")
+	disabledAt := time.Now()
+	store.users[u.ID].DisabledAt = &disabledAt
+	if user, _ := whoami(env, auth); user != nil {
+		t.Error("signed in as a disabled user")
+	}
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	store := newFakeStore()
+	env := newTestEnv(t, store)
+	u := store.addUser(t, env, "alice", "correct horse", RoleMember)
+	auth := cookie(login(env, "alice", "correct horse"), "authentication")
+
+	r := httptest.NewRequest("POST", "/logout", nil)
+	r.AddCookie(auth)
+	w := httptest.NewRecorder()
+	env.UserCtx(http.HandlerFunc(env.Logout)).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("logout returned %d", w.Code)
+	}
+	if c := cookie(w, "authentication"); c == nil || c.MaxAge >= 0 {
+		t.Error("logout did not delete the authentication cookie")
+	}
+	id := sessionOf(t, env, auth)
+	if store.sessions[id].LoggedOutAt == nil {
+		t.Error("logout did not revoke the session")
+	}
+	if user, _ := whoami(env, auth); user != nil {
+		t.Error("the token still signs in after logout")
+	}
+	want := []string{ActionLogin, ActionLogout}
+	if got := store.actions(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("audit actions = %v, want %v", got, want)
+	}
+	if store.events[1].ActorID == nil || *store.events[1].ActorID != u.ID {
+		t.Error("logout was not audited as the user")
+	}
+}
@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"database/sql"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/sdwalsh/mirango-go/mailer"
+	"github.com/sdwalsh/mirango-go/models"
+	"github.com/sdwalsh/mirango-go/password"
+	"github.com/sdwalsh/mirango-go/token"
+)
+
+// fakeStore keeps users and sessions in memory, methods the tests do not need are left to
+// the embedded (nil) Datastore and panic when called
+type fakeStore struct {
+	models.Datastore
+	mu       sync.Mutex
+	users    map[uuid.UUID]*models.User
+	sessions map[uuid.UUID]*models.Session
+	creds    map[uuid.UUID][]models.WebAuthnCredential
+	// identities are the linked external identities by id
+	identities map[uuid.UUID]*models.UserIdentity
+	events     []models.AuditEvent
+	// search is the filter of the last SearchPosts call
+	search *models.SearchFilter
+	// history is what GetLoginHistory returns for every login
+	history models.LoginHistory
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		users:      make(map[uuid.UUID]*models.User),
+		sessions:   make(map[uuid.UUID]*models.Session),
+		creds:      make(map[uuid.UUID][]models.WebAuthnCredential),
+		identities: make(map[uuid.UUID]*models.UserIdentity),
+	}
+}
+
+// addUser stores a user with the digest of the password
+func (s *fakeStore) addUser(t *testing.T, env *Env, uname string, pass string, role string) *models.User {
+	t.Helper()
+	digest, err := env.hashPassword(pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &models.User{ID: uuid.New(), Uname: uname, Digest: digest, Role: role, Email: uname + "@example.com"}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.ID] = u
+	return u
+}
+
+// copyUser returns a copy so handlers cannot change the stored user
+func copyUser(u *models.User) *models.User {
+	c := *u
+	return &c
+}
+
+func (s *fakeStore) GetUserByID(user uuid.UUID) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[user]; ok {
+		return copyUser(u), nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeStore) GetUserByUname(uname string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Uname == uname {
+			return copyUser(u), nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeStore) GetUserByEmail(email string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if strings.EqualFold(u.Email, email) {
+			return copyUser(u), nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeStore) UpdateUserDigest(user uuid.UUID, digest []byte) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[user]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	u.Digest = digest
+	return copyUser(u), nil
+}
+
+func (s *fakeStore) InsertLoginAttempt(user uuid.UUID, ip string, userAgent string, succeeded bool) (*models.LoginAttempt, error) {
+	return &models.LoginAttempt{}, nil
+}
+
+func (s *fakeStore) GetLoginAttemptsByUser(user uuid.UUID, start int, end int) (*[]models.LoginAttempt, error) {
+	return &[]models.LoginAttempt{}, nil
+}
+
+func (s *fakeStore) GetLoginHistory(user uuid.UUID, ip string, userAgent string) (*models.LoginHistory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.history
+	return &h, nil
+}
+
+func (s *fakeStore) IncrementFailedLogins(user uuid.UUID) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[user]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	u.FailedLogins++
+	return copyUser(u), nil
+}
+
+func (s *fakeStore) LockUser(user uuid.UUID, until time.Time) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[user]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	u.LockedUntil = &until
+	return copyUser(u), nil
+}
+
+func (s *fakeStore) UnlockUser(user uuid.UUID) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[user]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	u.FailedLogins = 0
+	u.LockedUntil = nil
+	return copyUser(u), nil
+}
+
+func (s *fakeStore) CreateSession(user uuid.UUID, ip string, userAgent string, expiry time.Time) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := &models.Session{ID: uuid.New(), UserID: user, IPAddress: ip, UserAgent: userAgent, ExpiredAt: expiry, CreatedAt: time.Now()}
+	s.sessions[session.ID] = session
+	c := *session
+	return &c, nil
+}
+
+func (s *fakeStore) GetActiveSession(id uuid.UUID) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || session.LoggedOutAt != nil || !session.ExpiredAt.After(time.Now()) {
+		return nil, sql.ErrNoRows
+	}
+	c := *session
+	return &c, nil
+}
+
+func (s *fakeStore) RevokeSession(id uuid.UUID) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || session.LoggedOutAt != nil {
+		return nil, sql.ErrNoRows
+	}
+	now := time.Now()
+	session.LoggedOutAt = &now
+	c := *session
+	return &c, nil
+}
+
+func (s *fakeStore) InsertRefreshToken(session uuid.UUID, hash []byte, expiry time.Time) (*models.RefreshToken, error) {
+	return &models.RefreshToken{ID: uuid.New(), SessionID: session, TokenHash: hash, ExpiredAt: expiry}, nil
+}
+
+func (s *fakeStore) GetWebAuthnCredentialsByUser(user uuid.UUID) (*[]models.WebAuthnCredential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	creds := append([]models.WebAuthnCredential{}, s.creds[user]...)
+	return &creds, nil
+}
+
+func (s *fakeStore) SearchPosts(f models.SearchFilter) (*[]models.SearchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.search = &f
+	return &[]models.SearchResult{}, nil
+}
+
+// DeleteUserIdentity keeps the last identity of a user without a password like the
+// database does
+func (s *fakeStore) DeleteUserIdentity(id uuid.UUID, user uuid.UUID) (*models.UserIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.identities[id]
+	if !ok || i.UserID != user {
+		return nil, sql.ErrNoRows
+	}
+	if len(s.users[user].Digest) == 0 {
+		left := 0
+		for _, other := range s.identities {
+			if other.UserID == user {
+				left++
+			}
+		}
+		if left == 1 {
+			return nil, models.ErrLastIdentity
+		}
+	}
+	delete(s.identities, id)
+	return i, nil
+}
+
+func (s *fakeStore) InsertPasswordReset(user uuid.UUID, hash []byte, expiry time.Time) (*models.PasswordReset, error) {
+	return &models.PasswordReset{ID: uuid.New(), UserID: user, TokenHash: hash, ExpiredAt: expiry}, nil
+}
+
+func (s *fakeStore) InsertTag(name string, slug string) (*models.Tag, error) {
+	return &models.Tag{ID: uuid.New(), Name: name, Slug: slug}, nil
+}
+
+func (s *fakeStore) InsertAuditEvent(e *models.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, *e)
+	return nil
+}
+
+// actions returns the actions of the recorded audit events in order
+func (s *fakeStore) actions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var actions []string
+	for _, e := range s.events {
+		actions = append(actions, e.Action)
+	}
+	return actions
+}
+
+// fakeMailer records sent messages
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *fakeMailer) Send(msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// newTestEnv returns an Env backed by the fake store with cheap password hashing
+func newTestEnv(t *testing.T, store *fakeStore) *Env {
+	t.Helper()
+	key, err := token.NewKey("test", "HS256", []byte("test secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := token.NewKeyring("mirango-go", "mirango-go", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Env{
+		DB:         store,
+		Audit:      store,
+		Tokens:     keyring,
+		AccessTTL:  15 * time.Minute,
+		RefreshTTL: 24 * time.Hour,
+		Mailer:     &fakeMailer{},
+		Passwords:  &password.PHC{Algorithm: "bcrypt", BcryptCost: 4},
+		Sugar:      zap.NewNop().Sugar(),
+	}
+}
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/sdwalsh/mirango-go/models"
+	"github.com/sdwalsh/mirango-go/slug"
+)
+
+var errUnknownTag = errors.New("unknown tag")
+
+// taggedPost is a post with its tags as returned by the single post routes
+type taggedPost struct {
+	*models.Post
+	Tags []models.Tag `json:"tags"`
+}
+
+// withTags loads the post's tags
+func (env *Env) withTags(p *models.Post) (*taggedPost, error) {
+	tags, err := env.DB.GetPostTags(p.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &taggedPost{p, *tags}, nil
+}
+
+// formTags resolves the tag slugs in the form's tags values, ok is false when the form has
+// no tags field. Unknown slugs are an error
+func (env *Env) formTags(r *http.Request) (ids []uuid.UUID, ok bool, err error) {
+	r.ParseForm()
+	slugs, ok := r.Form["tags"]
+	if !ok {
+		return nil, false, nil
+	}
+	tags, err := env.DB.FindTagsBySlugs(slugs)
+	if err != nil {
+		return nil, true, err
+	}
+	found := make(map[string]bool)
+	for _, t := range *tags {
+		found[t.Slug] = true
+		ids = append(ids, t.ID)
+	}
+	for _, slug := range slugs {
+		if !found[slug] {
+			return nil, true, errUnknownTag
+		}
+	}
+	return ids, true, nil
+}
+
+// GetTags returns every tag with the number of published posts carrying it
+func (env *Env) GetTags(w http.ResponseWriter, r *http.Request) {
+	env.writeTags(w, r, true)
+}
+
+// GetAllTags returns every tag with the number of posts carrying it including drafts
+func (env *Env) GetAllTags(w http.ResponseWriter, r *http.Request) {
+	env.writeTags(w, r, false)
+}
+
+func (env *Env) writeTags(w http.ResponseWriter, r *http.Request, published bool) {
+	t, err := env.DB.GetTags(published)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(t)
+}
+
+// GetTagPosts returns the published posts carrying the tag in the slug url param
+// Query string s and e define which rows to query s defaults to 0 and e defaults to s+10,
+// a negative s or an e before s is a 400
+func (env *Env) GetTagPosts(w http.ResponseWriter, r *http.Request) {
+	start, end, ok := pageRange(w, r, 10)
+	if !ok {
+		return
+	}
+	t, err := env.DB.FindTagBySlug(chi.URLParam(r, "slug"))
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	p, err := env.DB.PublishedPostsByTag(t.ID, start, end)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(p)
+}
+
+// formTag reads the name and slug of a tag from the form and writes 400 if either is blank.
+// The name is stored as typed (it is plain text, not HTML) and the slug is made from the
+// raw input so it can be used in /tags/{slug} urls
+func formTag(w http.ResponseWriter, r *http.Request) (name string, tagSlug string, ok bool) {
+	name = strings.TrimSpace(r.FormValue("name"))
+	tagSlug = slug.Make(r.FormValue("slug"))
+	if name == "" || tagSlug == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return "", "", false
+	}
+	return name, tagSlug, true
+}
+
+// CreateTag takes name and slug from a form and returns 201 with the tag, 409 if the name
+// or slug is taken. The slug is normalized like post slugs
+func (env *Env) CreateTag(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	name, tagSlug, ok := formTag(w, r)
+	if !ok {
+		return
+	}
+	t, err := env.DB.InsertTag(name, tagSlug)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	env.audit(r, user, ActionTagCreate, targetTag, &t.ID, nil, t)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+// UpdateTag takes name and slug from a form and renames the tag in the tagID url param
+func (env *Env) UpdateTag(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	id, err := uuid.Parse(chi.URLParam(r, "tagID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	name, tagSlug, ok := formTag(w, r)
+	if !ok {
+		return
+	}
+	tag, err := env.DB.FindTag(id)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	t, err := env.DB.UpdateTag(tag.ID, name, tagSlug)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	env.audit(r, user, ActionTagUpdate, targetTag, &t.ID, tag, t)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(t)
+}
+
+// DeleteTag deletes the tag in the tagID url param and removes it from every post
+func (env *Env) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	id, err := uuid.Parse(chi.URLParam(r, "tagID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	t, err := env.DB.DeleteTag(id)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	env.audit(r, user, ActionTagDelete, targetTag, &t.ID, t, nil)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+func TestCreateTag(t *testing.T) {
+	store := newFakeStore()
+	env := newTestEnv(t, store)
+	admin := store.addUser(t, env, "admin", "correct horse", RoleAdmin)
+	tests := []struct {
+		name, slug string
+		code       int
+		want       models.Tag
+	}{
+		{"Go", "go", http.StatusCreated, models.Tag{Name: "Go", Slug: "go"}},
+		{" R&D ", "R&D", http.StatusCreated, models.Tag{Name: "R&D", Slug: "r-and-d"}},
+		{"Paths", "a/b", http.StatusCreated, models.Tag{Name: "Paths", Slug: "a-b"}},
+		{"Crème", "Crème Brûlée", http.StatusCreated, models.Tag{Name: "Crème", Slug: "creme-brulee"}},
+		{"Nothing", "!!!", http.StatusBadRequest, models.Tag{}},
+		{"", "empty", http.StatusBadRequest, models.Tag{}},
+	}
+	for _, tt := range tests {
+		form := url.Values{"name": {tt.name}, "slug": {tt.slug}}
+		r := httptest.NewRequest("POST", "/tags", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r = r.WithContext(context.WithValue(r.Context(), contextUser, admin))
+		w := httptest.NewRecorder()
+		env.CreateTag(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%q/%q: got %d, want %d", tt.name, tt.slug, w.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusCreated {
+			continue
+		}
+		var got models.Tag
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != tt.want.Name || got.Slug != tt.want.Slug {
+			t.Errorf("%q/%q: created %q/%q, want %q/%q", tt.name, tt.slug, got.Name, got.Slug, tt.want.Name, tt.want.Slug)
+		}
+	}
+}
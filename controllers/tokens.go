@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/gorilla/csrf"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/sdwalsh/mirango-go/models"
+	"github.com/sdwalsh/mirango-go/token"
+)
+
+// Scopes that can be granted to API tokens
+const (
+	ScopePostsRead   = "posts:read"
+	ScopePostsWrite  = "posts:write"
+	ScopeImagesWrite = "images:write"
+)
+
+// apiTokenPrefix makes tokens easy to recognize (e.g. by secret scanners)
+const apiTokenPrefix = "mrg_"
+
+var validScopes = map[string]bool{
+	ScopePostsRead:   true,
+	ScopePostsWrite:  true,
+	ScopeImagesWrite: true,
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header or an empty string
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(h[7:])
+}
+
+// tokenUser looks up the user an API token belongs to and records that the token was used
+func (env *Env) tokenUser(bearer string) (*models.User, *models.APIToken, error) {
+	if !strings.HasPrefix(bearer, apiTokenPrefix) {
+		return nil, nil, errors.New("malformed api token")
+	}
+	t, err := env.DB.GetActiveAPIToken(token.Hash(bearer))
+	if err != nil {
+		return nil, nil, err
+	}
+	u, err := env.DB.GetUserByID(t.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.DisabledAt != nil {
+		return nil, nil, errDisabled
+	}
+	err = env.DB.TouchAPIToken(t.ID)
+	return u, t, err
+}
+
+// SkipCSRFForTokens exempts requests carrying a bearer token from gorilla/csrf, browsers
+// never attach the Authorization header on their own so these requests cannot be forged.
+// Wrap the csrf.Protect handler with this (UserCtx never falls back to cookies for them).
+func SkipCSRFForTokens(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if bearerToken(r) != "" {
+			r = csrf.UnsafeSkipCheck(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScope blocks requests authenticated by an API token that was not granted the scope,
+// requests from browser sessions are not limited by scopes
+func (env *Env) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t, ok := r.Context().Value(contextAPIToken).(*models.APIToken); ok {
+				granted := false
+				for _, s := range t.Scopes {
+					granted = granted || s == scope
+				}
+				if !granted {
+					http.Error(w, http.StatusText(403), 403)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SessionOnly blocks requests authenticated by an API token (e.g. so a token cannot mint new tokens)
+func (env *Env) SessionOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(contextSession).(*models.Session); !ok {
+			http.Error(w, http.StatusText(403), 403)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CreateAPIToken takes name, scope (repeatable) and an optional expires_in duration (e.g. 720h)
+// from a form and returns the new token, the token itself is only ever shown in this response
+func (env *Env) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	s := bluemonday.UGCPolicy()
+	user := ctx.Value(contextUser).(*models.User)
+	name := s.Sanitize(r.FormValue("name"))
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// FormValue has already parsed the form
+	scopes := r.Form["scope"]
+	if len(scopes) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, scope := range scopes {
+		if !validScopes[scope] {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	var expiry *time.Time
+	if v := r.FormValue("expires_in"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			env.log(r, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		t := time.Now().Add(d)
+		expiry = &t
+	}
+	plain, _, err := token.Opaque()
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	plain = apiTokenPrefix + plain
+	t, err := env.DB.InsertAPIToken(user.ID, name, token.Hash(plain), scopes, expiry)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Token    string           `json:"token"`
+		APIToken *models.APIToken `json:"api_token"`
+	}{plain, t})
+}
+
+// GetAPITokens returns the signed in user's active API tokens (without the tokens themselves)
+func (env *Env) GetAPITokens(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	t, err := env.DB.GetAPITokensByUser(user.ID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(t)
+}
+
+// DeleteAPIToken revokes one of the signed in user's API tokens
+func (env *Env) DeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	id, err := uuid.Parse(chi.URLParam(r, "tokenID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	_, err = env.DB.RevokeAPIToken(id, user.ID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
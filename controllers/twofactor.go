@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/sdwalsh/mirango-go/models"
+	"github.com/sdwalsh/mirango-go/token"
+	"github.com/sdwalsh/mirango-go/totp"
+)
+
+// mfaTTL is how long a user has to enter their code after the password was accepted
+const mfaTTL = 5 * time.Minute
+
+// recoveryCodeCount is the number of recovery codes handed out when enabling two factor
+const recoveryCodeCount = 10
+
+var errSecondFactor = errors.New("invalid two factor code")
+
+// MFAClaim is the custom claim proving the first login step succeeded for the uuid
+type MFAClaim struct {
+	UUID uuid.UUID `json:"uuid"`
+	jwt.StandardClaims
+}
+
+// mfaTokens signs MFA tokens for their own audience so they are never accepted by UserCtx
+func (env *Env) mfaTokens() *token.Keyring {
+	return env.Tokens.WithAudience(env.Tokens.Audience + "/mfa")
+}
+
+// completeLogin starts a session once the user proved who they are, users with two factor
+// enabled first receive an MFA token to exchange for a session at LoginTOTP
+func (env *Env) completeLogin(w http.ResponseWriter, r *http.Request, u *models.User) {
+	if locked(w, u) || disabled(w, u) {
+		return
+	}
+	if u.TOTPEnabled {
+		mfa := env.mfaTokens()
+		tokenString, err := mfa.Sign(MFAClaim{u.ID, mfa.Standard(uuid.New().String(), mfaTTL)})
+		if err != nil {
+			env.log(r, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"mfa_token": tokenString})
+		return
+	}
+	env.signIn(w, r, u)
+}
+
+// signIn starts a session for a user that passed every factor
+func (env *Env) signIn(w http.ResponseWriter, r *http.Request, u *models.User) {
+	_, err := env.startSession(w, r, u)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery code
+func (env *Env) verifySecondFactor(u *models.User, code string, recovery string) error {
+	if recovery != "" {
+		return env.DB.UseRecoveryCode(u.ID, token.Hash(normalizeRecoveryCode(recovery)))
+	}
+	if u.TOTPSecret == nil {
+		return errSecondFactor
+	}
+	step, ok := totp.Validate(*u.TOTPSecret, code, time.Now(), 1)
+	if !ok {
+		return errSecondFactor
+	}
+	_, err := env.DB.UseTOTPStep(u.ID, step)
+	return err
+}
+
+// generateRecoveryCodes returns new recovery codes and the hashes to store
+func generateRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([][]byte, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, token.Hash(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes in recovery codes
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// LoginTOTP takes mfa_token and code (or recovery_code) from a form and starts a session
+// if the code matches the user's authenticator
+func (env *Env) LoginTOTP(w http.ResponseWriter, r *http.Request) {
+	claims := new(MFAClaim)
+	err := env.mfaTokens().Parse(r.FormValue("mfa_token"), claims)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	u, err := env.DB.GetUserByID(claims.UUID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if locked(w, u) || disabled(w, u) {
+		return
+	}
+	err = env.verifySecondFactor(u, r.FormValue("code"), r.FormValue("recovery_code"))
+	if err != nil {
+		env.log(r, err)
+		env.loginFailed(r, u)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	env.signIn(w, r, u)
+}
+
+// EnrollTOTP generates a new secret for the signed in user and returns it with the
+// otpauth URI, two factor is enabled once a code is confirmed with ConfirmTOTP
+func (env *Env) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	if user.TOTPEnabled {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_, err = env.DB.SetTOTPSecret(user.ID, secret)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret": secret,
+		"uri":    totp.URI(env.Tokens.Issuer, user.Uname, secret),
+	})
+}
+
+// ConfirmTOTP takes a code from a form, enables two factor if it matches the pending
+// secret and returns the recovery codes (they are only shown once)
+func (env *Env) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	if user.TOTPEnabled || user.TOTPSecret == nil {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	step, ok := totp.Validate(*user.TOTPSecret, r.FormValue("code"), time.Now(), 1)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_, err = env.DB.EnableTOTP(user.ID, step, hashes)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// RegenerateRecoveryCodes takes a code (or recovery_code) from a form and replaces every
+// recovery code of the signed in user
+func (env *Env) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	if !user.TOTPEnabled {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	err := env.verifySecondFactor(user, r.FormValue("code"), r.FormValue("recovery_code"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_, err = env.DB.EnableTOTP(user.ID, user.TOTPLastStep, hashes)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// DisableTOTP takes a code (or recovery_code) from a form and turns off two factor
+func (env *Env) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	if !user.TOTPEnabled {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	err := env.verifySecondFactor(user, r.FormValue("code"), r.FormValue("recovery_code"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	_, err = env.DB.DisableTOTP(user.ID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
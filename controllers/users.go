@@ -2,18 +2,17 @@ package controllers
 
 import (
 	"context"
-	"fmt"
 	"net/http"
-	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 	"github.com/microcosm-cc/bluemonday"
+	"github.com/sdwalsh/mirango-go/gpg"
 	"github.com/sdwalsh/mirango-go/models"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // UserCustomClaim is the custom claim for user authentication contains a uuid.UUID and jwt.StandardClaims
+// the jwt id (jti) is the id of the session the token belongs to
 type UserCustomClaim struct {
 	UUID uuid.UUID `json:"uuid"`
 	jwt.StandardClaims
@@ -35,72 +34,88 @@ var (
 	contextSignedIn = contextKey("signed_in")
 	contextUser     = contextKey("user")
 	contextAdmin    = contextKey("admin")
+	contextSession  = contextKey("session")
+	contextAPIToken = contextKey("api_token")
 )
 
 // getUser is a useful function for taking the user claim from the jwt
-func (env *Env) getUser(tokenString string, hmacSecret []byte) (uuid.UUID, error) {
-	u := *new(uuid.UUID)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return hmacSecret, nil
-	})
-	if err != nil {
-		return u, err
-	}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		u, err = uuid.Parse(claims["uuid"].(string))
-		return u, err
-	}
-	return u, err
+func (env *Env) getUser(tokenString string) (*UserCustomClaim, error) {
+	claims := new(UserCustomClaim)
+	err := env.Tokens.Parse(tokenString, claims)
+	return claims, err
 }
 
-// UserCtx loads the user into the context if it exists
+// UserCtx loads the user into the context if the request has a valid API token or
+// the authentication cookie holds a valid jwt for an active session
 func (env *Env) UserCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Context defaults to false for signed in
 		ctx := context.WithValue(r.Context(), contextSignedIn, false)
 		ctx = context.WithValue(ctx, contextAdmin, false)
+		// API tokens never fall back to the cookie since they are exempt from csrf checks
+		if bearer := bearerToken(r); bearer != "" {
+			user, t, err := env.tokenUser(bearer)
+			if err != nil {
+				env.log(r, err)
+				http.Error(w, http.StatusText(401), 401)
+				return
+			}
+			ctx = context.WithValue(ctx, contextSignedIn, true)
+			ctx = context.WithValue(ctx, contextUser, user)
+			ctx = context.WithValue(ctx, contextAPIToken, t)
+			ctx = context.WithValue(ctx, contextAdmin, user.Role == RoleAdmin)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
 		cookie, err := r.Cookie("authentication")
 		if err != nil {
 			env.log(r, err)
 			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
-		ID, err := uuid.Parse(cookie.Value)
+		claims, err := env.getUser(cookie.Value)
 		if err != nil {
 			env.log(r, err)
 			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
-		user, err := env.DB.GetUserByID(ID)
+		ID, err := uuid.Parse(claims.Id)
 		if err != nil {
 			env.log(r, err)
 			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
-		// SignedIn is updated to true if it reaches this point and adds the user struct
-		ctx = context.WithValue(r.Context(), contextSignedIn, true)
+		// Logged out or expired sessions are not found in active_sessions
+		session, err := env.DB.GetActiveSession(ID)
+		if err != nil || session.UserID != claims.UUID {
+			env.log(r, err)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		user, err := env.DB.GetUserByID(session.UserID)
+		if err == nil && user.DisabledAt != nil {
+			err = errDisabled
+		}
+		if err != nil {
+			env.log(r, err)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		// SignedIn is updated to true if it reaches this point and adds the user and session structs
+		ctx = context.WithValue(ctx, contextSignedIn, true)
 		ctx = context.WithValue(ctx, contextUser, user)
-		ctx = context.WithValue(ctx, contextAdmin, true)
+		ctx = context.WithValue(ctx, contextSession, session)
+		ctx = context.WithValue(ctx, contextAdmin, user.Role == RoleAdmin)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// AdminOnly blocks all requests unless from a user with a role of ADMIN
+// SignedInOnly blocks all requests that do not belong to a signed in user
 // assumes user is stored in context (run UserCtx before running this middleware)
-func (env *Env) AdminOnly(next http.Handler) http.Handler {
+func (env *Env) SignedInOnly(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		user, ok := ctx.Value(contextUser).(*models.User)
-		// User was not found, invalid jwt, or not signed in
-		if !ok {
-			http.Error(w, http.StatusText(404), 404)
-			return
-		}
-		// If user is not an admin return unauthorized
-		if user.Role != "ADMIN" {
+		if _, ok := ctx.Value(contextUser).(*models.User); !ok {
 			http.Error(w, http.StatusText(401), 401)
 			return
 		}
@@ -108,68 +123,88 @@ func (env *Env) AdminOnly(next http.Handler) http.Handler {
 	})
 }
 
+// hashPassword returns the digest to store for a password
+func (env *Env) hashPassword(password string) ([]byte, error) {
+	digest, err := env.Passwords.Hash(password)
+	return []byte(digest), err
+}
+
 // Login takes a user and password from a login form and checks it against
-// the hashed password in the database sets jwt if accepted
+// the hashed password in the database and starts a new session if accepted
+// (users with two factor enabled receive an mfa_token for LoginTOTP instead)
 func (env *Env) Login(w http.ResponseWriter, r *http.Request) {
 	// Clean everything but the password (password is hashed)
 	s := bluemonday.UGCPolicy()
 	uname := s.Sanitize(r.FormValue("user"))
 	password := r.FormValue("password")
-	t := time.Now().Add(time.Hour)
 
-	// Database call and bcrypt compare hashed passwords
+	// Database call and compare hashed passwords
 	u, err := env.DB.GetUserByUname(uname)
 	if err != nil {
 		env.log(r, err)
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	err = bcrypt.CompareHashAndPassword(u.Digest, []byte(password+env.Salt))
+	// Locked accounts are rejected before the password is checked
+	if locked(w, u) {
+		return
+	}
+	// Accounts without a password can only log in through their linked identity
+	if len(u.Digest) == 0 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	ok, rehash, err := env.Passwords.Verify(password, string(u.Digest))
 	if err != nil {
 		env.log(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-
-	// Generate new jwt with uuid claim and add cookie
-	token := jwt.NewWithClaims(jwt.SigningMethodES512, UserCustomClaim{
-		u.ID,
-		jwt.StandardClaims{
-			ExpiresAt: t.Unix(),
-		},
-	})
-	tokenString, err := token.SignedString(env.Hmac)
-	cookie := http.Cookie{
-		Name:     "authentication",
-		Value:    tokenString,
-		Expires:  t,
-		HttpOnly: true,
+	if !ok {
+		env.loginFailed(r, u)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
 	}
-	http.SetCookie(w, &cookie)
-	w.WriteHeader(http.StatusOK)
+	// Digests made with outdated parameters (or before argon2id) are upgraded transparently
+	if rehash {
+		if digest, err := env.hashPassword(password); err != nil {
+			env.log(r, err)
+		} else if _, err = env.DB.UpdateUserDigest(u.ID, digest); err != nil {
+			env.log(r, err)
+		}
+	}
+
+	// Record the session server side (after the second factor if enabled)
+	env.completeLogin(w, r, u)
 }
 
-// Logout will delete the authentication cookie
+// Logout revokes the current session (and with it the refresh tokens) and deletes the cookies
 func (env *Env) Logout(w http.ResponseWriter, r *http.Request) {
-	// Setting MaxAge to < 0 will delete cookie now
-	cookie := http.Cookie{
-		Name:     "authentication",
-		MaxAge:   -1,
-		HttpOnly: true,
+	ctx := r.Context()
+	if session, ok := ctx.Value(contextSession).(*models.Session); ok {
+		_, err := env.DB.RevokeSession(session.ID)
+		if err != nil {
+			env.log(r, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		user, _ := ctx.Value(contextUser).(*models.User)
+		env.audit(r, user, ActionLogout, targetSession, &session.ID, nil, nil)
 	}
-	http.SetCookie(w, &cookie)
+	clearTokens(w)
 	w.WriteHeader(http.StatusOK)
 }
 
 // CreateAccount takes user, email, gpg, password, password2 from a form
 // cleans any data that might show up on a page and returns 500 if the two passwords do not match
-// 409 if there is an error in hashing and 200 if the user is added to the database successfully
+// 400 if the gpg key is invalid, 409 if there is an error in hashing and 200 if the user is
+// added to the database successfully
 func (env *Env) CreateAccount(w http.ResponseWriter, r *http.Request) {
-	// Clean everything but the password (password is hashed)
+	// Clean everything but the password (password is hashed) and gpg key (validated below)
 	s := bluemonday.UGCPolicy()
 	user := s.Sanitize(r.FormValue("user"))
 	email := s.Sanitize(r.FormValue("email"))
-	gpg := s.Sanitize(r.FormValue("gpg"))
+	gpgKey := r.FormValue("gpg")
 	password := r.FormValue("password")
 	password2 := r.FormValue("password2")
 
@@ -178,20 +213,44 @@ func (env *Env) CreateAccount(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusUnauthorized)
 	}
 
+	// The gpg key is optional but has to be a valid public key when given
+	fingerprint := ""
+	if gpgKey != "" {
+		var err error
+		fingerprint, err = gpg.Fingerprint(gpgKey)
+		if err != nil {
+			env.log(r, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Generate the hash if there is an error in hashing we'll return 500
-	digest, err := bcrypt.GenerateFromPassword([]byte(password+env.Salt), 10)
+	digest, err := env.hashPassword(password)
 	if err != nil {
 		env.log(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	// Ignore the user struct returned and report back 409 if error or 202 if ok
-	_, err = env.DB.InsertUser(user, digest, "MEMBER", email, gpg)
-	if err != nil {
+	// Report back 409 if error or 202 if ok, the account stays unverified until the
+	// emailed link is followed
+	u, err := env.DB.InsertUser(user, digest, RoleMember, email, gpgKey, fingerprint)
+	if uniqueViolation(err) {
 		env.log(r, err)
 		w.WriteHeader(http.StatusConflict)
 		return
 	}
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	admin, _ := r.Context().Value(contextUser).(*models.User)
+	env.audit(r, admin, ActionAccountCreate, targetUser, &u.ID, nil, u)
+	err = env.sendVerification(u, u.Email)
+	if err != nil {
+		env.log(r, err)
+	}
 	w.WriteHeader(http.StatusAccepted)
 }
@@ -0,0 +1,401 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+// webauthnCookie holds the webauthn.SessionData between the begin and finish requests
+const webauthnCookie = "webauthn"
+
+var errCloned = errors.New("webauthn signature counter did not increase, authenticator may be cloned")
+
+// webauthnUser adapts a user and their registered credentials to webauthn.User
+type webauthnUser struct {
+	user        *models.User
+	credentials []models.WebAuthnCredential
+}
+
+// WebAuthnID is the user handle, the user's uuid
+func (wu *webauthnUser) WebAuthnID() []byte {
+	return wu.user.ID[:]
+}
+
+// WebAuthnName is the uname
+func (wu *webauthnUser) WebAuthnName() string {
+	return wu.user.Uname
+}
+
+// WebAuthnDisplayName is the uname
+func (wu *webauthnUser) WebAuthnDisplayName() string {
+	return wu.user.Uname
+}
+
+// WebAuthnIcon is deprecated and left blank
+func (wu *webauthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+// WebAuthnCredentials converts the stored credentials
+func (wu *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, 0, len(wu.credentials))
+	for _, c := range wu.credentials {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(c.Transports))
+		for _, t := range c.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		creds = append(creds, webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: uint32(c.SignCount),
+			},
+		})
+	}
+	return creds
+}
+
+// exclusions lists the registered credentials so they are not registered twice
+func (wu *webauthnUser) exclusions() []protocol.CredentialDescriptor {
+	var list []protocol.CredentialDescriptor
+	for _, c := range wu.WebAuthnCredentials() {
+		list = append(list, c.Descriptor())
+	}
+	return list
+}
+
+// webauthnUser loads the user's credentials
+func (env *Env) webauthnUser(u *models.User) (*webauthnUser, error) {
+	creds, err := env.DB.GetWebAuthnCredentialsByUser(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &webauthnUser{u, *creds}, nil
+}
+
+// saveWebAuthnSession stores the ceremony's session data in a secure cookie
+func (env *Env) saveWebAuthnSession(w http.ResponseWriter, session *webauthn.SessionData) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	encoded, err := env.S.Encode(webauthnCookie, data)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     webauthnCookie,
+		Value:    encoded,
+		Path:     "/",
+		Expires:  session.Expires,
+		HttpOnly: true,
+	})
+	return nil
+}
+
+// readWebAuthnSession decodes and deletes the session data cookie
+func (env *Env) readWebAuthnSession(w http.ResponseWriter, r *http.Request) (*webauthn.SessionData, error) {
+	cookie, err := r.Cookie(webauthnCookie)
+	if err != nil {
+		return nil, err
+	}
+	http.SetCookie(w, &http.Cookie{Name: webauthnCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+	var data []byte
+	err = env.S.Decode(webauthnCookie, cookie.Value, &data)
+	if err != nil {
+		return nil, err
+	}
+	session := new(webauthn.SessionData)
+	return session, json.Unmarshal(data, session)
+}
+
+// writeWebAuthnOptions sends the options for navigator.credentials.create() or get()
+func writeWebAuthnOptions(w http.ResponseWriter, options interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(options)
+}
+
+// BeginWebAuthnRegistration returns the options to create a new credential for the signed in user
+func (env *Env) BeginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	if env.WebAuthn == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	wu, err := env.webauthnUser(user)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	options, session, err := env.WebAuthn.BeginRegistration(wu,
+		webauthn.WithExclusions(wu.exclusions()),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationRequired,
+		}),
+	)
+	if err == nil {
+		err = env.saveWebAuthnSession(w, session)
+	}
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeWebAuthnOptions(w, options)
+}
+
+// FinishWebAuthnRegistration verifies the new credential in the request body and stores it
+// under the name query string
+func (env *Env) FinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	if env.WebAuthn == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	ctx := r.Context()
+	s := bluemonday.UGCPolicy()
+	user := ctx.Value(contextUser).(*models.User)
+	name := s.Sanitize(r.URL.Query().Get("name"))
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	session, err := env.readWebAuthnSession(w, r)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	wu, err := env.webauthnUser(user)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	cred, err := env.WebAuthn.FinishRegistration(wu, *session, r)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	transports := make([]string, 0, len(cred.Transport))
+	for _, t := range cred.Transport {
+		transports = append(transports, string(t))
+	}
+	c, err := env.DB.InsertWebAuthnCredential(&models.WebAuthnCredential{
+		UserID:          user.ID,
+		Name:            name,
+		CredentialID:    cred.ID,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       int64(cred.Authenticator.SignCount),
+		Transports:      transports,
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+	})
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	env.audit(r, user, ActionWebAuthnRegister, targetWebAuthn, &c.ID, nil, c)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// GetWebAuthnCredentials returns the signed in user's credentials
+func (env *Env) GetWebAuthnCredentials(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	creds, err := env.DB.GetWebAuthnCredentialsByUser(user.ID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(creds)
+}
+
+// RenameWebAuthnCredential takes a name from a form and renames one of the signed in user's credentials
+func (env *Env) RenameWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	s := bluemonday.UGCPolicy()
+	user := ctx.Value(contextUser).(*models.User)
+	id, err := uuid.Parse(chi.URLParam(r, "credentialID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	name := s.Sanitize(r.FormValue("name"))
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// Credentials belonging to someone else are reported as not found
+	c, err := env.DB.RenameWebAuthnCredential(id, user.ID, name)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(c)
+}
+
+// DeleteWebAuthnCredential removes one of the signed in user's credentials
+func (env *Env) DeleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(contextUser).(*models.User)
+	id, err := uuid.Parse(chi.URLParam(r, "credentialID"))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// Credentials belonging to someone else are reported as not found
+	c, err := env.DB.DeleteWebAuthnCredential(id, user.ID)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	env.audit(r, user, ActionWebAuthnRemove, targetWebAuthn, &c.ID, c, nil)
+	w.WriteHeader(http.StatusOK)
+}
+
+// BeginWebAuthnLogin returns the options to sign in with a credential, with a user from the
+// form only that user's credentials are allowed otherwise any passkey (discoverable
+// credential) can be used
+func (env *Env) BeginWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if env.WebAuthn == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	verification := webauthn.WithUserVerification(protocol.VerificationRequired)
+	var options *protocol.CredentialAssertion
+	var session *webauthn.SessionData
+	var err error
+	if uname := r.FormValue("user"); uname != "" {
+		var u *models.User
+		u, err = env.DB.GetUserByUname(uname)
+		if err != nil {
+			env.log(r, err)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var wu *webauthnUser
+		wu, err = env.webauthnUser(u)
+		if err != nil {
+			env.log(r, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(wu.credentials) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		options, session, err = env.WebAuthn.BeginLogin(wu, verification)
+	} else {
+		options, session, err = env.WebAuthn.BeginDiscoverableLogin(verification)
+	}
+	if err == nil {
+		err = env.saveWebAuthnSession(w, session)
+	}
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeWebAuthnOptions(w, options)
+}
+
+// FinishWebAuthnLogin verifies the assertion in the request body and starts a session like
+// Login, the credential's signature counter has to increase on every login. The user was
+// verified by the authenticator so TOTP is not asked for
+func (env *Env) FinishWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if env.WebAuthn == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	session, err := env.readWebAuthnSession(w, r)
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var wu *webauthnUser
+	var cred *webauthn.Credential
+	if len(session.UserID) > 0 {
+		var id uuid.UUID
+		var u *models.User
+		id, err = uuid.FromBytes(session.UserID)
+		if err == nil {
+			u, err = env.DB.GetUserByID(id)
+		}
+		if err == nil {
+			wu, err = env.webauthnUser(u)
+		}
+		if err == nil {
+			cred, err = env.WebAuthn.FinishLogin(wu, *session, r)
+		}
+	} else {
+		cred, err = env.WebAuthn.FinishDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			id, err := uuid.FromBytes(userHandle)
+			if err != nil {
+				return nil, err
+			}
+			u, err := env.DB.GetUserByID(id)
+			if err != nil {
+				return nil, err
+			}
+			wu, err = env.webauthnUser(u)
+			return wu, err
+		}, *session, r)
+	}
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	// Locked and disabled accounts are turned away before anything is recorded
+	if locked(w, wu.user) || disabled(w, wu.user) {
+		return
+	}
+	if cred.Authenticator.CloneWarning {
+		env.log(r, errCloned)
+		env.loginFailed(r, wu.user)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	_, err = env.DB.UseWebAuthnCredential(cred.ID, int64(cred.Authenticator.SignCount))
+	if err != nil {
+		env.log(r, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	env.signIn(w, r, wu.user)
+}
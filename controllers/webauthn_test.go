@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/gorilla/securecookie"
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+// beginWebAuthnLogin posts the form to BeginWebAuthnLogin and returns the response
+func beginWebAuthnLogin(env *Env, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/auth/webauthn/login/begin", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	env.BeginWebAuthnLogin(w, r)
+	return w
+}
+
+// newWebAuthnEnv returns a test env with a passkey registered for alice
+func newWebAuthnEnv(t *testing.T, config *webauthn.Config) *Env {
+	t.Helper()
+	store := newFakeStore()
+	env := newTestEnv(t, store)
+	env.S = securecookie.New(securecookie.GenerateRandomKey(64), securecookie.GenerateRandomKey(32))
+	// A zero WebAuthn skips the validation in webauthn.New so an invalid config can fail
+	// the ceremony itself
+	env.WebAuthn = &webauthn.WebAuthn{Config: config}
+	u := store.addUser(t, env, "alice", "correct horse", RoleMember)
+	store.creds[u.ID] = []models.WebAuthnCredential{{ID: uuid.New(), UserID: u.ID, CredentialID: []byte("credential")}}
+	return env
+}
+
+func TestBeginWebAuthnLogin(t *testing.T) {
+	env := newWebAuthnEnv(t, &webauthn.Config{
+		RPDisplayName: "mirango-go",
+		RPID:          "example.com",
+		RPOrigins:     []string{"https://example.com"},
+	})
+	for _, form := range []url.Values{{"user": {"alice"}}, {}} {
+		w := beginWebAuthnLogin(env, form)
+		if w.Code != http.StatusOK {
+			t.Errorf("begin login with %v returned %d", form, w.Code)
+		}
+		if cookie(w, webauthnCookie) == nil {
+			t.Errorf("begin login with %v did not set the session cookie", form)
+		}
+	}
+	if w := beginWebAuthnLogin(env, url.Values{"user": {"bob"}}); w.Code != http.StatusNotFound {
+		t.Errorf("begin login for an unknown user returned %d, want 404", w.Code)
+	}
+}
+
+func TestBeginWebAuthnLoginError(t *testing.T) {
+	// Without a relying party BeginLogin and BeginDiscoverableLogin fail
+	env := newWebAuthnEnv(t, &webauthn.Config{})
+	for _, form := range []url.Values{{"user": {"alice"}}, {}} {
+		w := beginWebAuthnLogin(env, form)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("begin login with %v returned %d, want 500", form, w.Code)
+		}
+		if cookie(w, webauthnCookie) != nil {
+			t.Errorf("begin login with %v set a session cookie", form)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Package diff compares texts split into lines or words with the Myers O(ND) algorithm
+// and formats the result as edits or a unified diff
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Op is the kind of an edit
+type Op string
+
+// Edit operations, the values double as unified diff line prefixes
+const (
+	Equal  Op = " "
+	Insert Op = "+"
+	Delete Op = "-"
+)
+
+// Edit is a piece of text kept, inserted into or deleted from the old text
+type Edit struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// Lines splits s after every newline, the last line has none if s does not end with one
+func Lines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Words splits s into runs of whitespace and runs of everything else so joining the
+// pieces gives back s
+func Words(s string) []string {
+	var words []string
+	start := 0
+	space := false
+	for i, c := range s {
+		if i > start && unicode.IsSpace(c) != space {
+			words = append(words, s[start:i])
+			start = i
+		}
+		space = unicode.IsSpace(c)
+	}
+	if start < len(s) {
+		words = append(words, s[start:])
+	}
+	return words
+}
+
+// MaxEdits bounds the work Diff does, the saved paths grow with the square of the number
+// of edits. Texts that need more edits than this (after their common prefix and suffix)
+// are diffed as the old part deleted and the new part inserted
+const MaxEdits = 1000
+
+// Diff returns the shortest list of edits turning a into b, one edit per element, or a plain
+// replacement if that takes more than MaxEdits edits
+func Diff(a []string, b []string) []Edit {
+	// Common prefix and suffix are kept as is
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	var edits []Edit
+	for _, s := range a[:pre] {
+		edits = append(edits, Edit{Equal, s})
+	}
+	edits = append(edits, myers(a[pre:len(a)-suf], b[pre:len(b)-suf])...)
+	for _, s := range a[len(a)-suf:] {
+		edits = append(edits, Edit{Equal, s})
+	}
+	return edits
+}
+
+// myers finds the shortest edit script by following the furthest reaching path on every
+// diagonal k = x - y for d = 0, 1, ... edits and then walks the saved paths back from (n, m).
+// It gives up after MaxEdits and replaces a with b
+func myers(a []string, b []string) []Edit {
+	n, m := len(a), len(b)
+	max := n + m
+	if max > MaxEdits {
+		max = MaxEdits
+	}
+	off := max
+	v := make([]int, 2*max+2)
+	// trace[d] holds v[-d..d] as it was before step d
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b)
+			}
+		}
+	}
+	return replace(a, b)
+}
+
+// replace deletes all of a and inserts all of b
+func replace(a []string, b []string) []Edit {
+	edits := make([]Edit, 0, len(a)+len(b))
+	for _, s := range a {
+		edits = append(edits, Edit{Delete, s})
+	}
+	for _, s := range b {
+		edits = append(edits, Edit{Insert, s})
+	}
+	return edits
+}
+
+func backtrack(trace [][]int, a []string, b []string) []Edit {
+	var edits []Edit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[k-1+d] < v[k+1+d]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[prevK+d]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			edits = append(edits, Edit{Equal, a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			edits = append(edits, Edit{Insert, b[y-1]})
+		} else {
+			edits = append(edits, Edit{Delete, a[x-1]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 {
+		edits = append(edits, Edit{Equal, a[x-1]})
+		x--
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// Compact joins neighbouring edits with the same operation, deletions are placed before
+// insertions where they touch
+func Compact(edits []Edit) []Edit {
+	var out []Edit
+	for i := 0; i < len(edits); {
+		if edits[i].Op == Equal {
+			j := i
+			var text bytes.Buffer
+			for ; j < len(edits) && edits[j].Op == Equal; j++ {
+				text.WriteString(edits[j].Text)
+			}
+			out = append(out, Edit{Equal, text.String()})
+			i = j
+			continue
+		}
+		j := i
+		var del, ins bytes.Buffer
+		for ; j < len(edits) && edits[j].Op != Equal; j++ {
+			if edits[j].Op == Delete {
+				del.WriteString(edits[j].Text)
+			} else {
+				ins.WriteString(edits[j].Text)
+			}
+		}
+		if del.Len() > 0 {
+			out = append(out, Edit{Delete, del.String()})
+		}
+		if ins.Len() > 0 {
+			out = append(out, Edit{Insert, ins.String()})
+		}
+		i = j
+	}
+	return out
+}
+
+// Unified returns the differences between the lines of a and b as a unified diff with
+// context lines around every change, it is empty when a and b are the same
+func Unified(fromName string, toName string, a []string, b []string, context int) string {
+	edits := Diff(a, b)
+	// Line number in a and b before every edit
+	ai := make([]int, len(edits)+1)
+	bi := make([]int, len(edits)+1)
+	var changes []int
+	for i, e := range edits {
+		ai[i+1], bi[i+1] = ai[i], bi[i]
+		if e.Op != Insert {
+			ai[i+1]++
+		}
+		if e.Op != Delete {
+			bi[i+1]++
+		}
+		if e.Op != Equal {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for c := 0; c < len(changes); {
+		start := changes[c] - context
+		if start < 0 {
+			start = 0
+		}
+		// Changes closer than two contexts apart share a hunk
+		last := c
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*context+1 {
+			last++
+		}
+		end := changes[last] + context + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(ai[start], ai[end]), hunkRange(bi[start], bi[end]))
+		for _, e := range edits[start:end] {
+			out.WriteString(string(e.Op))
+			out.WriteString(e.Text)
+			if !strings.HasSuffix(e.Text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		c = last + 1
+	}
+	return out.String()
+}
+
+// hunkRange formats lines from to to of a hunk header, empty ranges start at the line
+// before them
+func hunkRange(from int, to int) string {
+	length := to - from
+	switch length {
+	case 0:
+		return fmt.Sprintf("%d,0", from)
+	case 1:
+		return fmt.Sprintf("%d", from+1)
+	}
+	return fmt.Sprintf("%d,%d", from+1, length)
+}
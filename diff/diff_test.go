@@ -0,0 +1,240 @@
+package diff
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// apply returns the old and new texts the edits describe
+func apply(edits []Edit) (string, string) {
+	var a, b strings.Builder
+	for _, e := range edits {
+		if e.Op != Insert {
+			a.WriteString(e.Text)
+		}
+		if e.Op != Delete {
+			b.WriteString(e.Text)
+		}
+	}
+	return a.String(), b.String()
+}
+
+// changes counts the inserted and deleted elements
+func changes(edits []Edit) int {
+	n := 0
+	for _, e := range edits {
+		if e.Op != Equal {
+			n++
+		}
+	}
+	return n
+}
+
+// lcs is the length of the longest common subsequence of a and b
+func lcs(a []string, b []string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] > cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{}},
+		{"a", []string{"a"}},
+		{"a\n", []string{"a\n"}},
+		{"a\nb", []string{"a\n", "b"}},
+		{"a\n\nb\n", []string{"a\n", "\n", "b\n"}},
+	}
+	for _, tt := range tests {
+		if got := Lines(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Lines(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"one", []string{"one"}},
+		{"one two", []string{"one", " ", "two"}},
+		{"  héllo,\tworld\n", []string{"  ", "héllo,", "\t", "world", "\n"}},
+	}
+	for _, tt := range tests {
+		got := Words(tt.in)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Words(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if strings.Join(got, "") != tt.in {
+			t.Errorf("Words(%q) does not join back to the input", tt.in)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	split := func(s string) []string { return strings.Split(s, "") }
+	tests := []struct {
+		a, b string
+		want []Edit
+	}{
+		{"", "", nil},
+		{"abc", "abc", []Edit{{Equal, "a"}, {Equal, "b"}, {Equal, "c"}}},
+		{"", "ab", []Edit{{Insert, "a"}, {Insert, "b"}}},
+		{"ab", "", []Edit{{Delete, "a"}, {Delete, "b"}}},
+		{"abc", "axc", []Edit{{Equal, "a"}, {Delete, "b"}, {Insert, "x"}, {Equal, "c"}}},
+		{"abcd", "acd", []Edit{{Equal, "a"}, {Delete, "b"}, {Equal, "c"}, {Equal, "d"}}},
+	}
+	for _, tt := range tests {
+		a, b := split(tt.a), split(tt.b)
+		if tt.a == "" {
+			a = nil
+		}
+		if tt.b == "" {
+			b = nil
+		}
+		if got := Diff(a, b); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Diff(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// TestDiffShortest checks random inputs against an LCS oracle, the edits have to rebuild
+// both texts with as few changes as possible
+func TestDiffShortest(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := func() []string {
+		s := make([]string, rnd.Intn(30))
+		for i := range s {
+			s[i] = string(rune('a' + rnd.Intn(4)))
+		}
+		return s
+	}
+	for i := 0; i < 500; i++ {
+		a, b := random(), random()
+		edits := Diff(a, b)
+		gotA, gotB := apply(edits)
+		if gotA != strings.Join(a, "") || gotB != strings.Join(b, "") {
+			t.Fatalf("Diff(%q, %q) = %v does not rebuild the texts", a, b, edits)
+		}
+		if got, want := changes(edits), len(a)+len(b)-2*lcs(a, b); got != want {
+			t.Fatalf("Diff(%q, %q) has %d changes, want %d", a, b, got, want)
+		}
+	}
+}
+
+func TestDiffMaxEdits(t *testing.T) {
+	// Nothing in common past the shared first and last lines
+	a := []string{"first\n"}
+	b := []string{"first\n"}
+	for i := 0; i < MaxEdits; i++ {
+		a = append(a, "old "+strconv.Itoa(i)+"\n")
+		b = append(b, "new "+strconv.Itoa(i)+"\n")
+	}
+	a = append(a, "last\n")
+	b = append(b, "last\n")
+
+	edits := Diff(a, b)
+	gotA, gotB := apply(edits)
+	if gotA != strings.Join(a, "") || gotB != strings.Join(b, "") {
+		t.Fatal("the replacement does not rebuild the texts")
+	}
+	compact := Compact(edits)
+	if len(compact) != 4 || compact[0].Op != Equal || compact[1].Op != Delete || compact[2].Op != Insert || compact[3].Op != Equal {
+		t.Errorf("expected the middle to be replaced, got %d edits", len(compact))
+	}
+
+	// Small changes in long texts still get the shortest diff
+	b = append([]string(nil), a...)
+	b[MaxEdits/2] = "changed\n"
+	if got := changes(Diff(a, b)); got != 2 {
+		t.Errorf("one changed line gave %d changes, want 2", got)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	tests := []struct {
+		in   []Edit
+		want []Edit
+	}{
+		{nil, nil},
+		{
+			[]Edit{{Equal, "a"}, {Equal, " "}, {Equal, "b"}},
+			[]Edit{{Equal, "a b"}},
+		},
+		{
+			// Deletions come first where they touch insertions
+			[]Edit{{Equal, "a "}, {Insert, "x"}, {Delete, "b"}, {Insert, "y"}, {Delete, "c"}, {Equal, " d"}},
+			[]Edit{{Equal, "a "}, {Delete, "bc"}, {Insert, "xy"}, {Equal, " d"}},
+		},
+		{
+			[]Edit{{Insert, "new"}, {Equal, " "}, {Delete, "old"}},
+			[]Edit{{Insert, "new"}, {Equal, " "}, {Delete, "old"}},
+		},
+	}
+	for _, tt := range tests {
+		if got := Compact(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Compact(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUnified(t *testing.T) {
+	numbers := func(n int, change map[int]string) string {
+		var b strings.Builder
+		for i := 1; i <= n; i++ {
+			if s, ok := change[i]; ok {
+				b.WriteString(s + "\n")
+				continue
+			}
+			b.WriteString(strconv.Itoa(i) + "\n")
+		}
+		return b.String()
+	}
+	tests := []struct {
+		name    string
+		a, b    string
+		context int
+		want    string
+	}{
+		{"same", "a\nb\n", "a\nb\n", 3, ""},
+		{"change", "a\nb\nc\nd\n", "a\nB\nc\nd\n", 3,
+			"--- old\n+++ new\n@@ -1,4 +1,4 @@\n a\n-b\n+B\n c\n d\n"},
+		{"from empty", "", "a\nb\n", 3,
+			"--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"no newline at end", "a\nb", "a\nc", 3,
+			"--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n"},
+		{"newline added", "a\nb", "a\nb\n", 3,
+			"--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n"},
+		{"separate hunks", numbers(20, nil), numbers(20, map[int]string{3: "three", 18: "eighteen"}), 2,
+			"--- old\n+++ new\n@@ -1,5 +1,5 @@\n 1\n 2\n-3\n+three\n 4\n 5\n@@ -16,5 +16,5 @@\n 16\n 17\n-18\n+eighteen\n 19\n 20\n"},
+		{"shared hunk", numbers(10, nil), numbers(10, map[int]string{3: "three", 7: "seven"}), 2,
+			"--- old\n+++ new\n@@ -1,9 +1,9 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n-7\n+seven\n 8\n 9\n"},
+	}
+	for _, tt := range tests {
+		got := Unified("old", "new", Lines(tt.a), Lines(tt.b), tt.context)
+		if got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Package gpg validates the public keys users register and verifies clearsigned
+// login challenges against them
+package gpg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+var (
+	// ErrKey is returned when the armored text is not exactly one public key
+	ErrKey = errors.New("gpg: expected a single armored public key")
+	// ErrMessage is returned when the signed text does not match the challenge
+	ErrMessage = errors.New("gpg: signed message does not match the challenge")
+	// ErrNotClearsigned is returned when the signature is not a clearsigned message
+	ErrNotClearsigned = errors.New("gpg: expected a clearsigned message")
+)
+
+// readKey parses an armored public key
+func readKey(armored string) (openpgp.EntityList, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return nil, err
+	}
+	if len(entities) != 1 || entities[0].PrivateKey != nil {
+		return nil, ErrKey
+	}
+	return entities, nil
+}
+
+// Fingerprint validates the armored public key and returns its fingerprint as upper case hex
+func Fingerprint(armored string) (string, error) {
+	entities, err := readKey(armored)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%X", entities[0].PrimaryKey.Fingerprint), nil
+}
+
+// VerifyClearsigned checks that message is the challenge clearsigned by the armored public key
+func VerifyClearsigned(armored string, message string, challenge string) error {
+	entities, err := readKey(armored)
+	if err != nil {
+		return err
+	}
+	block, _ := clearsign.Decode([]byte(message))
+	if block == nil {
+		return ErrNotClearsigned
+	}
+	if strings.TrimSpace(string(block.Plaintext)) != strings.TrimSpace(challenge) {
+		return ErrMessage
+	}
+	_, err = openpgp.CheckDetachedSignature(entities, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body)
+	return err
+}
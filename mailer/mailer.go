@@ -0,0 +1,101 @@
+// Package mailer sends the emails used for account recovery and notifications
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrHeader is returned when a header value contains a line break
+var ErrHeader = errors.New("mailer: invalid header value")
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages, use SMTP in production and Log for local testing
+type Mailer interface {
+	Send(m Message) error
+}
+
+// format returns the message with its headers ready to send
+func format(from string, m Message) ([]byte, error) {
+	for _, v := range []string{from, m.To, m.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, ErrHeader
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.Replace(m.Body, "\n", "\r\n", -1))
+	return b.Bytes(), nil
+}
+
+// SMTP sends messages through an SMTP server (PLAIN auth when Username is set)
+type SMTP struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// Send delivers the message to the SMTP server
+func (s *SMTP) Send(m Message) error {
+	msg, err := format(s.From, m)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, s.From, []string{m.To}, msg)
+}
+
+// Log writes messages to W instead of sending them (e.g. os.Stdout or a file)
+type Log struct {
+	From string
+	W    io.Writer
+	mu   sync.Mutex
+}
+
+// NewFile returns a Log that appends messages to the file at path
+func NewFile(from string, path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{From: from, W: f}, nil
+}
+
+// Send writes the message followed by a blank line
+func (l *Log) Send(m Message) error {
+	msg, err := format(l.From, m)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.W.Write(append(msg, "\r\n\r\n"...))
+	return err
+}
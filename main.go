@@ -1,21 +1,32 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/securecookie"
 	"github.com/jmoiron/sqlx"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/sdwalsh/mirango-go/controllers"
+	"github.com/sdwalsh/mirango-go/mailer"
 	"github.com/sdwalsh/mirango-go/models"
+	"github.com/sdwalsh/mirango-go/oidc"
+	"github.com/sdwalsh/mirango-go/password"
+	"github.com/sdwalsh/mirango-go/ratelimit"
+	"github.com/sdwalsh/mirango-go/scheduler"
+	"github.com/sdwalsh/mirango-go/token"
 )
 
 // Specification is the struct of all required environmental variables
@@ -27,6 +38,91 @@ type Specification struct {
 	Hmac     string
 	Salt     string
 	Port     string
+
+	// JWT signing: Hmac is the secret for HS256 / HS512, JWTKey is the path of a PEM
+	// private key for ES256 / EdDSA. Retired keys are kid:alg:secret-or-pem-path and
+	// only verify so tokens keep working while keys are rotated
+	JWTAlgorithm   string `default:"HS256"`
+	JWTKeyID       string `default:"1"`
+	JWTKey         string
+	JWTRetiredKeys map[string]string
+	JWTIssuer      string `default:"mirango"`
+	JWTAudience    string `default:"mirango"`
+
+	// Access tokens are short lived, refresh tokens live as long as their session
+	AccessTTL  time.Duration `default:"15m"`
+	RefreshTTL time.Duration `default:"336h"`
+
+	// Require ADMIN users to enable two factor authentication before using /admin
+	// (checked by the Require permission middleware)
+	RequireAdmin2FA bool
+
+	// Accounts are locked for LockoutBase after LockoutThreshold consecutive failed logins,
+	// doubling with every further failure up to LockoutMax (0 disables the lockout)
+	LockoutThreshold int           `default:"5"`
+	LockoutBase      time.Duration `default:"1m"`
+	LockoutMax       time.Duration `default:"24h"`
+
+	// Passwords are hashed with PasswordAlgorithm (argon2id or bcrypt) and an optional
+	// Pepper secret identified by PepperID, retired peppers are id:secret and keep
+	// verifying old digests until users log in again. Salt is only used to verify digests
+	// made before passwords were stored in PHC format
+	PasswordAlgorithm string `default:"argon2id"`
+	Argon2Memory      uint32 `default:"65536"`
+	Argon2Iterations  uint32 `default:"3"`
+	Argon2Parallelism uint8  `default:"4"`
+	BcryptCost        int    `default:"12"`
+	Pepper            string
+	PepperID          int `default:"1"`
+	RetiredPeppers    map[string]string
+
+	// Mail is sent through SMTPAddr (host:port) or appended to MailLog when it is not set
+	BaseURL      string `default:"http://localhost:8080"`
+	MailFrom     string `default:"mirango@localhost"`
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	MailLog      string `default:"mail.log"`
+
+	// OpenID Connect login is enabled when OIDCIssuer is set, OIDCRedirectURL defaults to
+	// BaseURL/login/oidc/callback. Unknown identities get an account with OIDCProvisionRole
+	// (e.g. MEMBER) when it is set, otherwise they have to be linked from /account first
+	OIDCIssuer        string
+	OIDCClientID      string
+	OIDCClientSecret  string
+	OIDCRedirectURL   string
+	OIDCScopes        []string `default:"openid,email,profile"`
+	OIDCProvisionRole string
+
+	// Passkey (WebAuthn) login is enabled when WebAuthnRPID (the site's domain) is set,
+	// WebAuthnOrigins defaults to BaseURL
+	WebAuthnRPID    string
+	WebAuthnRPName  string `default:"mirango"`
+	WebAuthnOrigins []string
+
+	// Every post update keeps the previous version, only the newest RevisionLimit revisions
+	// of a post are kept and none older than RevisionMaxAge (0 disables either limit)
+	RevisionLimit  int `default:"50"`
+	RevisionMaxAge time.Duration
+
+	// Scheduled posts are published every PublishInterval, PublishBatch at a time
+	PublishInterval time.Duration `default:"1m"`
+	PublishBatch    int           `default:"100"`
+
+	// Posts and images of deleted accounts are reassigned to the DeletedPostsOwner uname,
+	// they are anonymized (given to the ghost user) when it is not set
+	DeletedPostsOwner string
+
+	// Login and account creation throttling per ip_root
+	LoginLimit     int           `default:"10"`
+	LoginWindow    time.Duration `default:"15m"`
+	AccountLimit   int           `default:"5"`
+	AccountWindow  time.Duration `default:"1h"`
+	RatelimitPrune time.Duration `default:"10m"`
+
+	// X-Forwarded-For and X-Real-IP are only trusted from these proxy addresses or
+	// networks (comma separated, e.g. 127.0.0.1,10.0.0.0/8)
+	TrustedProxies []string
 }
 
 // Main sets up the server configuration and middleware and start the server
@@ -38,8 +134,6 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
-	log.Printf("%+v\n", c)
-
 	// Generate initial key for gorilla/csrf log.Fatal if key generation fails
 	key := make([]byte, 32)
 	_, err = rand.Read(key)
@@ -54,7 +148,8 @@ func main() {
 
 	// Database setup and ping
 	databaseOptions := "user=" + c.User + " password=" + c.Password + " dbname=" + c.Database + " sslmode=" + c.SSL
-	log.Print(databaseOptions)
+	// The configuration holds passwords, peppers and keys so only non secret values are logged
+	log.Printf("connecting to database %s as %s (sslmode=%s)", c.Database, c.User, c.SSL)
 	post, err := sqlx.Connect("postgres", databaseOptions)
 	if err != nil {
 		log.Fatal(err.Error())
@@ -67,19 +162,182 @@ func main() {
 	defer logger.Sync()
 	sugar := logger.Sugar()
 
+	// Token keyring for signing and verifying the authentication jwt
+	material := c.Hmac
+	if c.JWTKey != "" {
+		material = c.JWTKey
+	}
+	active, err := token.LoadKey(c.JWTKeyID, c.JWTAlgorithm, material)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	var retired []*token.Key
+	for kid, v := range c.JWTRetiredKeys {
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 {
+			log.Fatalf("Retired key %s must be alg:secret-or-pem-path", kid)
+		}
+		key, err := token.LoadKey(kid, parts[0], parts[1])
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		retired = append(retired, key)
+	}
+	tokens, err := token.NewKeyring(c.JWTIssuer, c.JWTAudience, active, retired...)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// Mail is only written to a file for local testing unless an SMTP server is configured
+	var mail mailer.Mailer
+	if c.SMTPAddr != "" {
+		mail = &mailer.SMTP{Addr: c.SMTPAddr, From: c.MailFrom, Username: c.SMTPUsername, Password: c.SMTPPassword}
+	} else {
+		mail, err = mailer.NewFile(c.MailFrom, c.MailLog)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	// Password hashing with versioned peppers
+	passwords := &password.PHC{
+		Algorithm:  c.PasswordAlgorithm,
+		Argon2:     password.DefaultArgon2,
+		BcryptCost: c.BcryptCost,
+		Peppers:    make(map[int][]byte),
+		LegacySalt: c.Salt,
+	}
+	passwords.Argon2.Memory = c.Argon2Memory
+	passwords.Argon2.Iterations = c.Argon2Iterations
+	passwords.Argon2.Parallelism = c.Argon2Parallelism
+	if c.Pepper != "" {
+		passwords.PepperID = c.PepperID
+		passwords.Peppers[c.PepperID] = []byte(c.Pepper)
+	}
+	for k, v := range c.RetiredPeppers {
+		id, err := strconv.Atoi(k)
+		if err != nil || id == 0 {
+			log.Fatalf("Retired pepper id %q must be a non zero number", k)
+		}
+		passwords.Peppers[id] = []byte(v)
+	}
+
+	// OpenID Connect issuer discovery
+	var provider *oidc.Provider
+	if c.OIDCIssuer != "" {
+		redirect := c.OIDCRedirectURL
+		if redirect == "" {
+			redirect = c.BaseURL + "/login/oidc/callback"
+		}
+		provider, err = oidc.Discover(context.Background(), oidc.Config{
+			Issuer:       c.OIDCIssuer,
+			ClientID:     c.OIDCClientID,
+			ClientSecret: c.OIDCClientSecret,
+			RedirectURL:  redirect,
+			Scopes:       c.OIDCScopes,
+		}, nil)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	// WebAuthn relying party
+	var passkeys *webauthn.WebAuthn
+	if c.WebAuthnRPID != "" {
+		origins := c.WebAuthnOrigins
+		if len(origins) == 0 {
+			origins = []string{c.BaseURL}
+		}
+		timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: 5 * time.Minute}
+		passkeys, err = webauthn.New(&webauthn.Config{
+			RPID:          c.WebAuthnRPID,
+			RPDisplayName: c.WebAuthnRPName,
+			RPOrigins:     origins,
+			Timeouts: webauthn.TimeoutsConfig{
+				Login:        timeout,
+				Registration: timeout,
+			},
+		})
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	// Owner of the posts of deleted accounts, the zero uuid is the ghost user
+	var heir uuid.UUID
+	if c.DeletedPostsOwner != "" {
+		owner, err := data.GetUserByUname(c.DeletedPostsOwner)
+		if err != nil {
+			log.Fatalf("Deleted posts owner %q not found", c.DeletedPostsOwner)
+		}
+		heir = owner.ID
+	}
+
+	// Called whenever a post goes live
+	hooks := []scheduler.Hook{
+		func(p *models.Post) {
+			sugar.Infow("post published", "post:", p.ID, "slug:", p.Slug)
+		},
+	}
+
+	// Forwarded client addresses are only read from requests sent by these proxies
+	proxies, err := controllers.ParseProxies(c.TrustedProxies)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
 	// Pass around Env to routes
 	e := controllers.Env{
-		DB:    data,
-		S:     s,
-		Hmac:  []byte(c.Hmac),
-		Salt:  c.Salt,
-		Sugar: sugar,
+		DB:              data,
+		Limiter:         data,
+		FallbackLimiter: ratelimit.NewMemory(),
+		Audit:           data,
+		S:               s,
+		Tokens:          tokens,
+		AccessTTL:       c.AccessTTL,
+		RefreshTTL:      c.RefreshTTL,
+		RequireAdmin2FA: c.RequireAdmin2FA,
+		Lockout: controllers.Lockout{
+			Threshold: c.LockoutThreshold,
+			Base:      c.LockoutBase,
+			Max:       c.LockoutMax,
+		},
+		Mailer:            mail,
+		BaseURL:           c.BaseURL,
+		Passwords:         passwords,
+		OIDC:              provider,
+		OIDCProvisionRole: c.OIDCProvisionRole,
+		WebAuthn:          passkeys,
+		RevisionLimit:     c.RevisionLimit,
+		RevisionMaxAge:    c.RevisionMaxAge,
+		PublishHooks:      hooks,
+		DeletedPostsOwner: heir,
+		Sugar:             sugar,
+	}
+
+	// Throttle rules and background removal of attempts older than the longest window
+	loginLimit := ratelimit.Rule{Bucket: "login", Limit: c.LoginLimit, Window: c.LoginWindow}
+	accountLimit := ratelimit.Rule{Bucket: "account", Limit: c.AccountLimit, Window: c.AccountWindow}
+	passwordLimit := ratelimit.Rule{Bucket: "password", Limit: c.AccountLimit, Window: c.AccountWindow}
+	emailLimit := ratelimit.Rule{Bucket: "email", Limit: c.AccountLimit, Window: c.AccountWindow}
+	keep := c.LoginWindow
+	if c.AccountWindow > keep {
+		keep = c.AccountWindow
 	}
+	go ratelimit.Prune(context.Background(), e.Limiter, c.RatelimitPrune, keep, func(err error) {
+		sugar.Infow("could not prune rate limit attempts", "error:", err)
+	})
+	go ratelimit.Prune(context.Background(), e.FallbackLimiter, c.RatelimitPrune, keep, nil)
+
+	// Background publishing of scheduled posts
+	go scheduler.Run(context.Background(), data, c.PublishInterval, c.PublishBatch, hooks, func(err error) {
+		sugar.Infow("could not publish scheduled posts", "error:", err)
+	})
 
 	// Create new chi router and add middleware
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(controllers.RealIP(proxies))
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.StripSlashes)
@@ -94,26 +352,124 @@ func main() {
 	// Post Routes
 	r.Get("/posts", e.GetPublishedPosts)
 	r.Get("/posts/{postID}", e.GetPost)
+	r.Get("/posts/by-slug/{slug}", e.GetPostBySlug)
+
+	// Search Routes
+	r.Get("/search", e.Search)
 
-	r.Post("/login", e.Login)
+	// Tag Routes
+	r.Get("/tags", e.GetTags)
+	r.Get("/tags/{slug}/posts", e.GetTagPosts)
+
+	// Public profiles
+	r.Get("/users/{uname}", e.GetProfile)
+
+	r.With(e.RateLimit(loginLimit)).Post("/login", e.Login)
+	r.With(e.RateLimit(loginLimit)).Post("/login/2fa", e.LoginTOTP)
+	r.With(e.RateLimit(loginLimit)).Post("/login/gpg/challenge", e.GPGChallenge)
+	r.With(e.RateLimit(loginLimit)).Post("/login/gpg", e.LoginGPG)
+	r.With(e.RateLimit(loginLimit)).Get("/login/oidc", e.LoginOIDC)
+	r.With(e.RateLimit(loginLimit)).Get("/login/oidc/callback", e.OIDCCallback)
+	r.With(e.RateLimit(loginLimit)).Post("/login/webauthn", e.BeginWebAuthnLogin)
+	r.With(e.RateLimit(loginLimit)).Post("/login/webauthn/finish", e.FinishWebAuthnLogin)
 	r.Post("/logout", e.Logout)
+	r.Post("/auth/refresh", e.Refresh)
+	r.With(e.RateLimit(passwordLimit)).Post("/auth/password/forgot", e.ForgotPassword)
+	r.With(e.RateLimit(passwordLimit)).Post("/auth/password/reset", e.ResetPassword)
+	r.With(e.RateLimit(emailLimit)).Post("/auth/email/verify", e.VerifyEmail)
+	r.With(e.RateLimit(accountLimit)).Post("/auth/invitation/accept", e.AcceptInvitation)
 
 	// User / Admin Routes
 
+	r.Route("/account", func(r chi.Router) {
+		r.Use(e.SignedInOnly)
+		r.Use(e.SessionOnly)
+
+		r.Get("/", e.GetAccount)
+		r.Put("/", e.UpdateAccount)
+		r.Delete("/", e.DeleteAccount)
+		r.Get("/export", e.ExportAccount)
+		r.With(e.RateLimit(emailLimit)).Put("/email", e.ChangeEmail)
+		r.With(e.RateLimit(emailLimit)).Post("/email/resend", e.ResendVerification)
+
+		r.Get("/identities", e.GetIdentities)
+		r.Get("/identities/oidc", e.LinkOIDC)
+		r.Delete("/identities/{identityID}", e.DeleteIdentity)
+
+		r.Get("/webauthn", e.GetWebAuthnCredentials)
+		r.Post("/webauthn/register", e.BeginWebAuthnRegistration)
+		r.Post("/webauthn/register/finish", e.FinishWebAuthnRegistration)
+		r.Put("/webauthn/{credentialID}", e.RenameWebAuthnCredential)
+		r.Delete("/webauthn/{credentialID}", e.DeleteWebAuthnCredential)
+
+		r.Get("/sessions", e.GetSessions)
+		r.Delete("/sessions", e.DeleteOtherSessions)
+		r.Delete("/sessions/{sessionID}", e.DeleteSession)
+
+		r.Post("/2fa", e.EnrollTOTP)
+		r.Post("/2fa/confirm", e.ConfirmTOTP)
+		r.Post("/2fa/recovery-codes", e.RegenerateRecoveryCodes)
+		r.Delete("/2fa", e.DisableTOTP)
+
+		r.Get("/tokens", e.GetAPITokens)
+		r.With(e.VerifiedOnly).Post("/tokens", e.CreateAPIToken)
+		r.Delete("/tokens/{tokenID}", e.DeleteAPIToken)
+	})
+
 	r.Route("/admin", func(r chi.Router) {
 		r.Use(e.UserCtx)
-		r.Use(e.AdminOnly)
+		r.Use(e.SignedInOnly)
+		r.Use(e.VerifiedOnly)
+
+		// Ownership and publishing are checked by the post controllers
+		r.Group(func(r chi.Router) {
+			r.Use(e.Require(controllers.PermPostCreate))
+
+			r.With(e.RequireScope(controllers.ScopePostsRead)).Get("/posts/unpublished", e.GetUnpublishedPosts)
+			r.With(e.RequireScope(controllers.ScopePostsWrite)).Post("/posts", e.CreatePost)
+			r.With(e.RequireScope(controllers.ScopePostsWrite)).Put("/posts/{postID}", e.UpdatePost)
+			r.With(e.RequireScope(controllers.ScopePostsWrite)).Delete("/posts/{postID}", e.DeletePost)
+			r.With(e.RequireScope(controllers.ScopePostsRead)).Get("/posts/{postID}/revisions", e.GetPostRevisions)
+			r.With(e.RequireScope(controllers.ScopePostsRead)).Get("/posts/{postID}/revisions/diff", e.DiffPostRevisions)
+			r.With(e.RequireScope(controllers.ScopePostsRead)).Get("/posts/{postID}/revisions/{revision}", e.GetPostRevision)
+			r.With(e.RequireScope(controllers.ScopePostsWrite)).Post("/posts/{postID}/revisions/{revision}/restore", e.RestorePostRevision)
+		})
+
+		r.Route("/tags", func(r chi.Router) {
+			r.Use(e.Require(controllers.PermTagManage))
+
+			r.With(e.RequireScope(controllers.ScopePostsRead)).Get("/", e.GetAllTags)
+			r.With(e.RequireScope(controllers.ScopePostsWrite)).Post("/", e.CreateTag)
+			r.With(e.RequireScope(controllers.ScopePostsWrite)).Put("/{tagID}", e.UpdateTag)
+			r.With(e.RequireScope(controllers.ScopePostsWrite)).Delete("/{tagID}", e.DeleteTag)
+		})
+
+		r.With(e.Require(controllers.PermAuditRead), e.SessionOnly).Get("/audit", e.GetAuditEvents)
 
-		r.Get("/posts/unpublished", e.GetUnpublishedPosts)
-		r.Post("/posts", e.CreatePost)
-		r.Put("/posts/{postID}", e.UpdatePost)
-		r.Delete("/posts/{postID}", e.DeletePost)
+		// Account administration is never available to API tokens
+		r.Group(func(r chi.Router) {
+			r.Use(e.Require(controllers.PermUserManage))
+			r.Use(e.SessionOnly)
 
-		r.Post("/users", e.CreateAccount)
+			r.Get("/users", e.ListUsers)
+			r.With(e.RateLimit(accountLimit)).Post("/users", e.CreateAccount)
+			r.Get("/invitations", e.GetInvitations)
+			r.With(e.RateLimit(accountLimit)).Post("/invitations", e.CreateInvitation)
+			r.Delete("/invitations/{invitationID}", e.RevokeInvitation)
+			r.Put("/users/{userID}/role", e.SetRole)
+			r.Post("/users/{userID}/disable", e.DisableUser)
+			r.Post("/users/{userID}/enable", e.EnableUser)
+			r.Get("/users/{userID}/login-attempts", e.GetLoginAttempts)
+			r.Post("/users/{userID}/unlock", e.UnlockAccount)
+			r.Get("/users/{userID}/sessions", e.GetUserSessions)
+			r.Delete("/users/{userID}/sessions", e.DeleteUserSessions)
+			r.Delete("/users/{userID}/sessions/{sessionID}", e.DeleteUserSession)
+		})
 	})
 
-	// Start server and add csrf middleware (32 bit key and chi router)
-	err = http.ListenAndServe(c.Port, csrf.Protect(key)(r))
+	// Start server and add csrf middleware (32 bit key and chi router), requests
+	// authenticated with an API token skip the csrf check
+	err = http.ListenAndServe(c.Port, controllers.SkipCSRFForTokens(csrf.Protect(key)(r)))
 	if err != nil {
 		log.Fatal("Cannot start server")
 	}
@@ -0,0 +1,5 @@
+DROP INDEX ratelimits__created_at;
+DROP INDEX ratelimits__bucket_ip_root;
+CREATE INDEX ratelimits__ip_root ON ratelimits (ip_root(ip_address));
+
+ALTER TABLE ratelimits DROP COLUMN bucket;
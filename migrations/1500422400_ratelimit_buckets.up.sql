@@ -0,0 +1,6 @@
+-- Attempts are counted per route so login and registration limits do not share a bucket
+ALTER TABLE ratelimits ADD COLUMN bucket text NOT NULL DEFAULT '';
+
+DROP INDEX ratelimits__ip_root;
+CREATE INDEX ratelimits__bucket_ip_root ON ratelimits (bucket, ip_root(ip_address), created_at);
+CREATE INDEX ratelimits__created_at ON ratelimits (created_at);
@@ -0,0 +1,12 @@
+-- Refresh tokens are rotated on every use, all tokens of a session form one family
+CREATE TABLE refresh_tokens (
+  id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  session_id    uuid NOT NULL REFERENCES sessions(id),
+  token_hash    bytea NOT NULL UNIQUE,
+  used_at       timestamptz NULL,
+  expired_at    timestamptz NOT NULL,
+  created_at    timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Speed up session_id FK joins
+CREATE INDEX refresh_tokens__session_id ON refresh_tokens (session_id);
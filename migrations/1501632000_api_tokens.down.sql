@@ -0,0 +1,2 @@
+DROP VIEW active_api_tokens;
+DROP TABLE api_tokens;
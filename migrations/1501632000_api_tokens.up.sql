@@ -0,0 +1,22 @@
+-- Personal access tokens for scripts, only the hash of the token is stored
+CREATE TABLE api_tokens (
+  id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id       uuid NOT NULL REFERENCES users(id),
+  name          text NOT NULL,
+  token_hash    bytea NOT NULL UNIQUE,
+  scopes        text[] NOT NULL,
+  last_used_at  timestamptz NULL,
+  revoked_at    timestamptz NULL,
+  expired_at    timestamptz NULL,
+  created_at    timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Speed up user_id FK joins
+CREATE INDEX api_tokens__user_id ON api_tokens (user_id);
+
+CREATE VIEW active_api_tokens AS
+  SELECT *
+  FROM api_tokens
+  WHERE revoked_at IS NULL
+    AND (expired_at IS NULL OR expired_at > NOW())
+;
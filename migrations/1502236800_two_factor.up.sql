@@ -0,0 +1,16 @@
+-- TOTP secret is stored while enrolling and only used once totp_enabled is set,
+-- totp_last_step stops a code from being used twice
+ALTER TABLE users ADD COLUMN totp_secret text NULL;
+ALTER TABLE users ADD COLUMN totp_enabled boolean NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN totp_last_step bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes (
+  id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id       uuid NOT NULL REFERENCES users(id),
+  code_hash     bytea NOT NULL UNIQUE,
+  used_at       timestamptz NULL,
+  created_at    timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Speed up user_id FK joins
+CREATE INDEX recovery_codes__user_id ON recovery_codes (user_id);
@@ -0,0 +1,3 @@
+DROP TABLE gpg_challenges;
+
+ALTER TABLE users DROP COLUMN gpg_fingerprint;
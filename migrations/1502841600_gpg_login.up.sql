@@ -0,0 +1,15 @@
+-- Fingerprint of users.gpg_key, empty when the user did not register a key
+ALTER TABLE users ADD COLUMN gpg_fingerprint text NOT NULL DEFAULT '';
+
+-- Single use nonces a user has to clearsign with their key to log in
+CREATE TABLE gpg_challenges (
+  id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id       uuid NOT NULL REFERENCES users(id),
+  nonce         text NOT NULL,
+  used_at       timestamptz NULL,
+  expired_at    timestamptz NOT NULL,
+  created_at    timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Speed up user_id FK joins
+CREATE INDEX gpg_challenges__user_id ON gpg_challenges (user_id);
@@ -0,0 +1,12 @@
+-- Single use password reset tokens, only the hash of the token is stored
+CREATE TABLE password_resets (
+  id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id       uuid NOT NULL REFERENCES users(id),
+  token_hash    bytea NOT NULL UNIQUE,
+  used_at       timestamptz NULL,
+  expired_at    timestamptz NOT NULL,
+  created_at    timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Speed up user_id FK joins
+CREATE INDEX password_resets__user_id ON password_resets (user_id);
@@ -0,0 +1,18 @@
+-- Accounts start unverified, accounts that already exist were created by an admin
+ALTER TABLE users ADD COLUMN email_verified_at timestamptz NULL;
+UPDATE users SET email_verified_at = created_at;
+
+-- Single use verification tokens, email is the address being verified (it replaces
+-- users.email once verified so profile changes only take effect after verification)
+CREATE TABLE email_verifications (
+  id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id       uuid NOT NULL REFERENCES users(id),
+  email         text NOT NULL,
+  token_hash    bytea NOT NULL UNIQUE,
+  used_at       timestamptz NULL,
+  expired_at    timestamptz NOT NULL,
+  created_at    timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Speed up user_id FK joins
+CREATE INDEX email_verifications__user_id ON email_verifications (user_id);
@@ -0,0 +1,11 @@
+-- Enum values cannot be dropped so the type is recreated, users with a removed role become members
+UPDATE users SET role = 'MEMBER' WHERE role IN ('EDITOR', 'AUTHOR', 'CONTRIBUTOR');
+
+ALTER TYPE user_role RENAME TO user_role_old;
+CREATE TYPE user_role AS ENUM ('ADMIN', 'MEMBER');
+
+ALTER TABLE users ALTER COLUMN role DROP DEFAULT;
+ALTER TABLE users ALTER COLUMN role TYPE user_role USING role::text::user_role;
+ALTER TABLE users ALTER COLUMN role SET DEFAULT 'MEMBER';
+
+DROP TYPE user_role_old;
@@ -0,0 +1,4 @@
+-- ALTER TYPE ... ADD VALUE cannot run inside a transaction block before PostgreSQL 12
+ALTER TYPE user_role ADD VALUE IF NOT EXISTS 'EDITOR';
+ALTER TYPE user_role ADD VALUE IF NOT EXISTS 'AUTHOR';
+ALTER TYPE user_role ADD VALUE IF NOT EXISTS 'CONTRIBUTOR';
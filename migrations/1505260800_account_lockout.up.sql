@@ -0,0 +1,20 @@
+-- Consecutive failed logins, the account is locked until locked_until once the
+-- threshold is reached
+ALTER TABLE users ADD COLUMN failed_logins integer NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN locked_until timestamptz NULL;
+
+-- Every login attempt (failed or not) so admins can review them and new
+-- ip_root() / user agent combinations can be detected
+CREATE TABLE login_attempts (
+  id            bigserial PRIMARY KEY,
+  user_id       uuid NOT NULL REFERENCES users(id),
+  ip_address    inet NOT NULL,
+  user_agent    text NOT NULL DEFAULT '',
+  succeeded     boolean NOT NULL,
+  created_at    timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Speed up user_id FK joins and listing a user's attempts
+CREATE INDEX login_attempts__user_id_created_at ON login_attempts (user_id, created_at);
+-- Known origins are looked up by network rather than exact address
+CREATE INDEX login_attempts__user_id_ip_root ON login_attempts (user_id, ip_root(ip_address)) WHERE succeeded;
@@ -0,0 +1,29 @@
+-- Append only log of administrative and content actions, actor_id has no foreign key
+-- so events outlive the users that caused them
+CREATE TABLE audit_events (
+  id            bigserial PRIMARY KEY,
+  actor_id      uuid NULL,
+  action        text NOT NULL,
+  target_type   text NOT NULL DEFAULT '',
+  target_id     uuid NULL,
+  ip_address    inet NOT NULL,
+  request_id    text NOT NULL DEFAULT '',
+  diff          jsonb NOT NULL DEFAULT '{}',
+  created_at    timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX audit_events__created_at ON audit_events (created_at);
+CREATE INDEX audit_events__actor_id_created_at ON audit_events (actor_id, created_at);
+CREATE INDEX audit_events__action_created_at ON audit_events (action, created_at);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS
+$$
+  BEGIN
+    RAISE EXCEPTION 'audit_events is append only';
+  END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events__append_only BEFORE UPDATE OR DELETE ON audit_events
+  FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only();
+CREATE TRIGGER audit_events__no_truncate BEFORE TRUNCATE ON audit_events
+  FOR EACH STATEMENT EXECUTE PROCEDURE audit_events_append_only();
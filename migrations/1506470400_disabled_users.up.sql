@@ -0,0 +1,2 @@
+-- Disabled users cannot log in and their sessions and API tokens stop working
+ALTER TABLE users ADD COLUMN disabled_at timestamptz NULL;
@@ -0,0 +1,18 @@
+-- Single use invitations, the invitee picks their own uname, password and gpg key and
+-- gets the role chosen by the admin. user_id is the account created on acceptance
+CREATE TABLE invitations (
+  id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  invited_by    uuid NULL REFERENCES users(id),
+  email         text NOT NULL,
+  role          user_role NOT NULL DEFAULT 'MEMBER',
+  token_hash    bytea NOT NULL UNIQUE,
+  user_id       uuid NULL REFERENCES users(id),
+  accepted_at   timestamptz NULL,
+  revoked_at    timestamptz NULL,
+  expired_at    timestamptz NOT NULL,
+  created_at    timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Speed up FK joins
+CREATE INDEX invitations__invited_by ON invitations (invited_by);
+CREATE INDEX invitations__user_id ON invitations (user_id);
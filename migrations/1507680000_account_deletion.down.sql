@@ -0,0 +1,26 @@
+ALTER TABLE invitations DROP CONSTRAINT invitations_user_id_fkey,
+  ADD CONSTRAINT invitations_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+ALTER TABLE invitations DROP CONSTRAINT invitations_invited_by_fkey,
+  ADD CONSTRAINT invitations_invited_by_fkey FOREIGN KEY (invited_by) REFERENCES users(id);
+
+ALTER TABLE login_attempts DROP CONSTRAINT login_attempts_user_id_fkey,
+  ADD CONSTRAINT login_attempts_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+ALTER TABLE email_verifications DROP CONSTRAINT email_verifications_user_id_fkey,
+  ADD CONSTRAINT email_verifications_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+ALTER TABLE password_resets DROP CONSTRAINT password_resets_user_id_fkey,
+  ADD CONSTRAINT password_resets_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+ALTER TABLE gpg_challenges DROP CONSTRAINT gpg_challenges_user_id_fkey,
+  ADD CONSTRAINT gpg_challenges_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+ALTER TABLE recovery_codes DROP CONSTRAINT recovery_codes_user_id_fkey,
+  ADD CONSTRAINT recovery_codes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+ALTER TABLE api_tokens DROP CONSTRAINT api_tokens_user_id_fkey,
+  ADD CONSTRAINT api_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+ALTER TABLE refresh_tokens DROP CONSTRAINT refresh_tokens_session_id_fkey,
+  ADD CONSTRAINT refresh_tokens_session_id_fkey FOREIGN KEY (session_id) REFERENCES sessions(id);
+ALTER TABLE sessions DROP CONSTRAINT sessions_user_id_fkey,
+  ADD CONSTRAINT sessions_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+
+-- The ghost user stays if it owns anything
+DELETE FROM users WHERE id = '00000000-0000-0000-0000-000000000000'
+  AND NOT EXISTS (SELECT 1 FROM posts WHERE user_id = '00000000-0000-0000-0000-000000000000')
+  AND NOT EXISTS (SELECT 1 FROM images WHERE user_id = '00000000-0000-0000-0000-000000000000');
@@ -0,0 +1,29 @@
+-- Posts and images of deleted accounts are handed to this placeholder unless another owner
+-- is configured, it is disabled and has no password so it can never log in
+INSERT INTO users (id, uname, digest, email, gpg_key, disabled_at)
+  VALUES ('00000000-0000-0000-0000-000000000000', 'ghost', '', '', '', NOW())
+  ON CONFLICT (id) DO NOTHING;
+
+-- Everything else a user owns goes with them
+ALTER TABLE sessions DROP CONSTRAINT sessions_user_id_fkey,
+  ADD CONSTRAINT sessions_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE refresh_tokens DROP CONSTRAINT refresh_tokens_session_id_fkey,
+  ADD CONSTRAINT refresh_tokens_session_id_fkey FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE;
+ALTER TABLE api_tokens DROP CONSTRAINT api_tokens_user_id_fkey,
+  ADD CONSTRAINT api_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE recovery_codes DROP CONSTRAINT recovery_codes_user_id_fkey,
+  ADD CONSTRAINT recovery_codes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE gpg_challenges DROP CONSTRAINT gpg_challenges_user_id_fkey,
+  ADD CONSTRAINT gpg_challenges_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE password_resets DROP CONSTRAINT password_resets_user_id_fkey,
+  ADD CONSTRAINT password_resets_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE email_verifications DROP CONSTRAINT email_verifications_user_id_fkey,
+  ADD CONSTRAINT email_verifications_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE login_attempts DROP CONSTRAINT login_attempts_user_id_fkey,
+  ADD CONSTRAINT login_attempts_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+-- Invitations are kept for the record
+ALTER TABLE invitations DROP CONSTRAINT invitations_invited_by_fkey,
+  ADD CONSTRAINT invitations_invited_by_fkey FOREIGN KEY (invited_by) REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE invitations DROP CONSTRAINT invitations_user_id_fkey,
+  ADD CONSTRAINT invitations_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
@@ -0,0 +1,13 @@
+-- External (OpenID Connect) identities linked to users, subject is the issuer's id for the user
+CREATE TABLE user_identities (
+  id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id       uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  issuer        text NOT NULL,
+  subject       text NOT NULL,
+  email         text NOT NULL DEFAULT '',
+  created_at    timestamptz NOT NULL DEFAULT NOW(),
+  UNIQUE (issuer, subject)
+);
+
+-- Speed up user_id FK joins
+CREATE INDEX user_identities__user_id ON user_identities (user_id);
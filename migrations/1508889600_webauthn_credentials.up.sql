@@ -0,0 +1,20 @@
+-- WebAuthn (passkey) credentials, credential_id is the id chosen by the authenticator and
+-- sign_count the last signature counter seen (0 for authenticators without a counter)
+CREATE TABLE webauthn_credentials (
+  id               uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id          uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name             text NOT NULL,
+  credential_id    bytea NOT NULL UNIQUE,
+  public_key       bytea NOT NULL,
+  attestation_type text NOT NULL DEFAULT '',
+  aaguid           bytea NOT NULL DEFAULT '',
+  sign_count       bigint NOT NULL DEFAULT 0,
+  transports       text[] NOT NULL DEFAULT '{}',
+  backup_eligible  boolean NOT NULL DEFAULT false,
+  backup_state     boolean NOT NULL DEFAULT false,
+  last_used_at     timestamptz NULL,
+  created_at       timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Speed up user_id FK joins
+CREATE INDEX webauthn_credentials__user_id ON webauthn_credentials (user_id);
@@ -0,0 +1,9 @@
+DROP INDEX posts_tags__tag_id;
+
+ALTER TABLE posts_tags DROP CONSTRAINT posts_tags_tag_id_fkey,
+  ADD CONSTRAINT posts_tags_tag_id_fkey FOREIGN KEY (tag_id) REFERENCES tags(id);
+ALTER TABLE posts_tags DROP CONSTRAINT posts_tags_post_id_fkey,
+  ADD CONSTRAINT posts_tags_post_id_fkey FOREIGN KEY (post_id) REFERENCES posts(id);
+
+ALTER TABLE posts_tags DROP CONSTRAINT posts_tags_pkey;
+ALTER TABLE tags DROP CONSTRAINT tags_slug_key, DROP CONSTRAINT tags_name_key;
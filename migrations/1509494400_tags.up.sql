@@ -0,0 +1,16 @@
+-- Tags are looked up by slug and a post can only carry a tag once
+ALTER TABLE tags ADD CONSTRAINT tags_name_key UNIQUE (name),
+  ADD CONSTRAINT tags_slug_key UNIQUE (slug);
+
+DELETE FROM posts_tags a USING posts_tags b
+  WHERE a.ctid < b.ctid AND a.post_id = b.post_id AND a.tag_id = b.tag_id;
+ALTER TABLE posts_tags ADD PRIMARY KEY (post_id, tag_id);
+
+-- Deleting a post or a tag removes it from posts_tags
+ALTER TABLE posts_tags DROP CONSTRAINT posts_tags_post_id_fkey,
+  ADD CONSTRAINT posts_tags_post_id_fkey FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE;
+ALTER TABLE posts_tags DROP CONSTRAINT posts_tags_tag_id_fkey,
+  ADD CONSTRAINT posts_tags_tag_id_fkey FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE;
+
+-- Speed up tag_id FK joins (post_id is covered by the primary key)
+CREATE INDEX posts_tags__tag_id ON posts_tags (tag_id);
@@ -0,0 +1,2 @@
+DROP TABLE slug_redirects;
+DROP INDEX posts__slug;
@@ -0,0 +1,18 @@
+-- Existing posts without a slug get their id, duplicates get their id appended since a
+-- numeric suffix can be taken by another post already (foo, foo and foo-2)
+UPDATE posts SET slug = id::text WHERE slug = '';
+UPDATE posts SET slug = posts.slug || '-' || posts.id::text
+  FROM (SELECT id, row_number() OVER (PARTITION BY slug ORDER BY created_at) AS n FROM posts) d
+  WHERE posts.id = d.id AND d.n > 1;
+
+CREATE UNIQUE INDEX posts__slug ON posts (slug);
+
+-- Slugs a post used to have so old links can redirect to the current one
+CREATE TABLE slug_redirects (
+  slug          text PRIMARY KEY,
+  post_id       uuid NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+  created_at    timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Speed up post_id FK joins
+CREATE INDEX slug_redirects__post_id ON slug_redirects (post_id);
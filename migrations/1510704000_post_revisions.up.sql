@@ -0,0 +1,19 @@
+-- Snapshot of a post taken before every update, numbered per post
+CREATE TABLE post_revisions (
+  id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  post_id       uuid NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+  revision      integer NOT NULL,
+  title         text NOT NULL,
+  slug          text NOT NULL,
+  sub_title     text NOT NULL,
+  short         text NOT NULL,
+  post_content  text NOT NULL,
+  digest        text NOT NULL,
+  published     boolean NOT NULL,
+  updated_at    timestamptz NOT NULL,
+  created_at    timestamptz NOT NULL DEFAULT NOW(),
+  UNIQUE (post_id, revision)
+);
+
+-- Speed up pruning by age
+CREATE INDEX post_revisions__created_at ON post_revisions (created_at);
@@ -0,0 +1,2 @@
+DROP INDEX posts__publish_at;
+ALTER TABLE posts DROP COLUMN published_at, DROP COLUMN publish_at;
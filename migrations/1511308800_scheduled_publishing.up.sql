@@ -0,0 +1,9 @@
+-- Unpublished posts with a publish_at are published by the scheduler once it has passed,
+-- published_at is when a post went live
+ALTER TABLE posts ADD COLUMN publish_at timestamptz NULL,
+  ADD COLUMN published_at timestamptz NULL;
+
+UPDATE posts SET published_at = updated_at WHERE published;
+
+-- Speed up finding due posts
+CREATE INDEX posts__publish_at ON posts (publish_at) WHERE publish_at IS NOT NULL AND NOT published;
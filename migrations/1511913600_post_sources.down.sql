@@ -0,0 +1,2 @@
+ALTER TABLE post_revisions DROP COLUMN source, DROP COLUMN source_format;
+ALTER TABLE posts DROP COLUMN source, DROP COLUMN source_format;
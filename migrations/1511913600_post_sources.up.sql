@@ -0,0 +1,9 @@
+-- Posts keep what the author wrote, post_content is the sanitized HTML rendered from it
+ALTER TABLE posts ADD COLUMN source_format text NOT NULL DEFAULT 'html'
+    CHECK (source_format IN ('markdown', 'html')),
+  ADD COLUMN source text NOT NULL DEFAULT '';
+UPDATE posts SET source = post_content;
+
+ALTER TABLE post_revisions ADD COLUMN source_format text NOT NULL DEFAULT 'html',
+  ADD COLUMN source text NOT NULL DEFAULT '';
+UPDATE post_revisions SET source = post_content;
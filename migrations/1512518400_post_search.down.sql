@@ -0,0 +1,2 @@
+DROP INDEX posts__search;
+ALTER TABLE posts DROP COLUMN search;
@@ -0,0 +1,10 @@
+-- Full text search document, title weighs most and content least (HTML tags are skipped
+-- by the parser). Generated columns need PostgreSQL 12
+ALTER TABLE posts ADD COLUMN search tsvector GENERATED ALWAYS AS (
+  setweight(to_tsvector('english', title), 'A') ||
+  setweight(to_tsvector('english', sub_title), 'B') ||
+  setweight(to_tsvector('english', short), 'C') ||
+  setweight(to_tsvector('english', post_content), 'D')
+) STORED;
+
+CREATE INDEX posts__search ON posts USING GIN (search);
@@ -0,0 +1,4 @@
+-- An email address belongs to one account at most (compared case insensitively) so password
+-- resets always reach the right user, the ghost user has no address. Accounts sharing an
+-- address have to be changed before this migration can run.
+CREATE UNIQUE INDEX users__email ON users (lower(email)) WHERE email <> '';
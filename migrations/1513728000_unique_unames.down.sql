@@ -0,0 +1 @@
+DROP INDEX users__uname;
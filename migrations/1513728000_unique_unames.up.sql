@@ -0,0 +1,4 @@
+-- unames identify users at login and on profiles so they have to be unique (compared case
+-- insensitively, alice and Alice are the same user). Accounts sharing a uname have to be
+-- renamed before this migration can run.
+CREATE UNIQUE INDEX users__uname ON users (lower(uname));
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditEvent struct based on audit_events table in database
+type AuditEvent struct {
+	ID         int64           `db:"id" json:"id"`
+	ActorID    *uuid.UUID      `db:"actor_id" json:"actor_id"`
+	Action     string          `db:"action" json:"action"`
+	TargetType string          `db:"target_type" json:"target_type"`
+	TargetID   *uuid.UUID      `db:"target_id" json:"target_id"`
+	IPAddress  string          `db:"ip_address" json:"ip_address"`
+	RequestID  string          `db:"request_id" json:"request_id"`
+	Diff       json.RawMessage `db:"diff" json:"diff"`
+	CreatedAt  time.Time       `db:"created_at" json:"created_at"`
+}
+
+// AuditFilter narrows down GetAuditEvents, zero values match every event
+type AuditFilter struct {
+	Actor  *uuid.UUID
+	Action string
+	Since  *time.Time
+	Until  *time.Time
+	Start  int
+	End    int
+}
+
+///////////////////////////
+// Audit Event Functions //
+///////////////////////////
+
+// InsertAuditEvent appends the event to the audit log
+func (db *DB) InsertAuditEvent(e *AuditEvent) error {
+	diff := "{}"
+	if len(e.Diff) > 0 {
+		diff = string(e.Diff)
+	}
+	sql := "INSERT INTO audit_events (actor_id, action, target_type, target_id, ip_address, request_id, diff) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	_, err := db.Exec(sql, e.ActorID, e.Action, e.TargetType, e.TargetID, e.IPAddress, e.RequestID, diff)
+	return err
+}
+
+// GetAuditEvents returns the audit events matching the filter newest first
+func (db *DB) GetAuditEvents(f AuditFilter) (*[]AuditEvent, error) {
+	total := f.End - f.Start
+	e := new([]AuditEvent)
+	sql := `SELECT * FROM audit_events
+		WHERE ($1::uuid IS NULL OR actor_id = $1)
+		AND ($2 = '' OR action = $2)
+		AND ($3::timestamptz IS NULL OR created_at >= $3)
+		AND ($4::timestamptz IS NULL OR created_at < $4)
+		ORDER BY created_at DESC OFFSET $5 LIMIT $6`
+	err := db.Select(e, sql, f.Actor, f.Action, f.Since, f.Until, f.Start, total)
+	return e, err
+}
+
+// GetAuditEventsByUser returns every audit event the user caused or that targeted the user
+func (db *DB) GetAuditEventsByUser(user uuid.UUID) (*[]AuditEvent, error) {
+	e := new([]AuditEvent)
+	sql := "SELECT * FROM audit_events WHERE actor_id = $1 OR (target_type = 'user' AND target_id = $1) ORDER BY created_at DESC"
+	err := db.Select(e, sql, user)
+	return e, err
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 
@@ -29,19 +31,111 @@ copies or substantial portions of the Software.
 // this allows us to mock the database during tests!
 type Datastore interface {
 	// User Functions
-	InsertUser(uname string, digest []byte, role string, email string, gpg string) (*User, error)
+	InsertUser(uname string, digest []byte, role string, email string, gpg string, fingerprint string) (*User, error)
 	GetUserByEmail(email string) (*User, error)
 	GetUserByID(user uuid.UUID) (*User, error)
 	GetUserByUname(uname string) (*User, error)
+	UpdateUserDigest(user uuid.UUID, digest []byte) (*User, error)
+	UpdateUser(user uuid.UUID, digest []byte, gpg string, fingerprint string) (*User, error)
+	ListUsers(start int, end int) (*[]User, error)
+	SetRole(user uuid.UUID, role string) (*User, error)
+	DisableUser(user uuid.UUID, disabled bool) (*User, error)
+	DeleteUser(user uuid.UUID, heir uuid.UUID) (*User, error)
+	// Email Verification Functions
+	InsertEmailVerification(user uuid.UUID, email string, hash []byte, expiry time.Time) (*EmailVerification, error)
+	LatestEmailVerification(user uuid.UUID) (*EmailVerification, error)
+	VerifyEmail(hash []byte) (*User, error)
+	// User Identity Functions
+	InsertUserIdentity(user uuid.UUID, issuer string, subject string, email string) (*UserIdentity, error)
+	GetUserIdentity(issuer string, subject string) (*UserIdentity, error)
+	GetUserIdentitiesByUser(user uuid.UUID) (*[]UserIdentity, error)
+	DeleteUserIdentity(id uuid.UUID, user uuid.UUID) (*UserIdentity, error)
+	ProvisionUser(issuer string, subject string, uname string, digest []byte, role string, email string) (*User, error)
+	// Invitation Functions
+	InsertInvitation(invitedBy uuid.UUID, email string, role string, hash []byte, expiry time.Time) (*Invitation, error)
+	GetInvitations(start int, end int) (*[]Invitation, error)
+	RevokeInvitation(id uuid.UUID) (*Invitation, error)
+	AcceptInvitation(hash []byte, uname string, digest []byte, gpg string, fingerprint string) (*User, error)
+	// Password Reset Functions
+	InsertPasswordReset(user uuid.UUID, hash []byte, expiry time.Time) (*PasswordReset, error)
+	UsePasswordReset(hash []byte) (*PasswordReset, error)
+	// Two Factor Functions
+	SetTOTPSecret(user uuid.UUID, secret string) (*User, error)
+	EnableTOTP(user uuid.UUID, step int64, recoveryHashes [][]byte) (*User, error)
+	DisableTOTP(user uuid.UUID) (*User, error)
+	UseTOTPStep(user uuid.UUID, step int64) (*User, error)
+	UseRecoveryCode(user uuid.UUID, hash []byte) error
+	// Login Attempt Functions
+	InsertLoginAttempt(user uuid.UUID, ip string, userAgent string, succeeded bool) (*LoginAttempt, error)
+	GetLoginAttemptsByUser(user uuid.UUID, start int, end int) (*[]LoginAttempt, error)
+	GetLoginHistory(user uuid.UUID, ip string, userAgent string) (*LoginHistory, error)
+	IncrementFailedLogins(user uuid.UUID) (*User, error)
+	LockUser(user uuid.UUID, until time.Time) (*User, error)
+	UnlockUser(user uuid.UUID) (*User, error)
+	// WebAuthn Credential Functions
+	InsertWebAuthnCredential(c *WebAuthnCredential) (*WebAuthnCredential, error)
+	GetWebAuthnCredentialsByUser(user uuid.UUID) (*[]WebAuthnCredential, error)
+	RenameWebAuthnCredential(id uuid.UUID, user uuid.UUID, name string) (*WebAuthnCredential, error)
+	DeleteWebAuthnCredential(id uuid.UUID, user uuid.UUID) (*WebAuthnCredential, error)
+	UseWebAuthnCredential(credentialID []byte, signCount int64) (*WebAuthnCredential, error)
+	// GPG Challenge Functions
+	InsertGPGChallenge(user uuid.UUID, nonce string, expiry time.Time) (*GPGChallenge, error)
+	UseGPGChallenge(id uuid.UUID) (*GPGChallenge, error)
+	// Session Functions
+	CreateSession(user uuid.UUID, ip string, userAgent string, expiry time.Time) (*Session, error)
+	GetActiveSession(id uuid.UUID) (*Session, error)
+	RevokeSession(id uuid.UUID) (*Session, error)
+	GetActiveSessionsByUser(user uuid.UUID) (*[]Session, error)
+	GetSessionsByUser(user uuid.UUID) (*[]Session, error)
+	RevokeUserSession(id uuid.UUID, user uuid.UUID) (*Session, error)
+	RevokeUserSessions(user uuid.UUID, except uuid.UUID) (*[]Session, error)
+	// Refresh Token Functions
+	InsertRefreshToken(session uuid.UUID, hash []byte, expiry time.Time) (*RefreshToken, error)
+	UseRefreshToken(hash []byte) (*RefreshToken, error)
+	FindRefreshToken(hash []byte) (*RefreshToken, error)
+	// API Token Functions
+	InsertAPIToken(user uuid.UUID, name string, hash []byte, scopes []string, expiry *time.Time) (*APIToken, error)
+	GetActiveAPIToken(hash []byte) (*APIToken, error)
+	GetAPITokensByUser(user uuid.UUID) (*[]APIToken, error)
+	RevokeAPIToken(id uuid.UUID, user uuid.UUID) (*APIToken, error)
+	TouchAPIToken(id uuid.UUID) error
+	// Audit Event Functions
+	InsertAuditEvent(e *AuditEvent) error
+	GetAuditEvents(f AuditFilter) (*[]AuditEvent, error)
+	GetAuditEventsByUser(user uuid.UUID) (*[]AuditEvent, error)
+	// Rate Limit Functions
+	RecordAttempt(bucket string, ip string) error
+	CountAttempts(bucket string, ip string, since time.Time) (int, time.Time, error)
+	PruneAttempts(before time.Time) (int64, error)
 	// Post Functions
 	PublishedPosts(start int, end int) (*[]Post, error)
 	UnpublishedPosts() (*[]Post, error)
+	UnpublishedPostsByUser(user uuid.UUID) (*[]Post, error)
 	GetPosts(start int, end int) (*[]Post, error)
 	FindPost(id uuid.UUID) (*Post, error)
+	FindPostBySlug(slug string) (*Post, error)
+	FindSlugRedirect(slug string) (*Post, error)
 	FindPostsByUser(user uuid.UUID) (*[]Post, error)
-	InsertPost(user uuid.UUID, title string, slug string, subtitle string, short string, content string, digest string, published bool) (*Post, error)
-	UpdatePost(id uuid.UUID, user uuid.UUID, title string, slug string, subtitle string, short string, content string, digest string, published bool) (*Post, error)
-	DeletePost(id uuid.UUID, user uuid.UUID) (*Post, error)
+	InsertPost(user uuid.UUID, f *PostFields) (*Post, error)
+	UpdatePost(id uuid.UUID, f *PostFields) (*Post, error)
+	DeletePost(id uuid.UUID) (*Post, error)
+	PublishDuePosts(limit int) (*[]Post, error)
+	// Post Revision Functions
+	GetPostRevisions(post uuid.UUID) (*[]PostRevision, error)
+	FindPostRevision(post uuid.UUID, revision int) (*PostRevision, error)
+	PrunePostRevisions(post uuid.UUID, keep int, before time.Time) (int64, error)
+	// Search Functions
+	SearchPosts(f SearchFilter) (*[]SearchResult, error)
+	// Tag Functions
+	GetTags(published bool) (*[]TagCount, error)
+	FindTag(id uuid.UUID) (*Tag, error)
+	FindTagBySlug(slug string) (*Tag, error)
+	FindTagsBySlugs(slugs []string) (*[]Tag, error)
+	InsertTag(name string, slug string) (*Tag, error)
+	UpdateTag(id uuid.UUID, name string, slug string) (*Tag, error)
+	DeleteTag(id uuid.UUID) (*Tag, error)
+	GetPostTags(post uuid.UUID) (*[]Tag, error)
+	PublishedPostsByTag(tag uuid.UUID, start int, end int) (*[]Post, error)
 	// Image Functions
 	AllImages() (*[]Image, error)
 	FindImage(id uuid.UUID) (*Image, error)
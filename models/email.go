@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EmailVerification struct based on email_verifications table in database
+type EmailVerification struct {
+	ID        uuid.UUID  `db:"id"`
+	UserID    uuid.UUID  `db:"user_id"`
+	Email     string     `db:"email"`
+	TokenHash []byte     `db:"token_hash"`
+	UsedAt    *time.Time `db:"used_at"`
+	ExpiredAt time.Time  `db:"expired_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+//////////////////////////////////
+// Email Verification Functions //
+//////////////////////////////////
+
+// InsertEmailVerification stores the hash of a token verifying email for the user until expiry
+func (db *DB) InsertEmailVerification(user uuid.UUID, email string, hash []byte, expiry time.Time) (*EmailVerification, error) {
+	v := new(EmailVerification)
+	sql := "INSERT INTO email_verifications (user_id, email, token_hash, expired_at) VALUES ($1, $2, $3, $4) RETURNING *"
+	err := db.Get(v, sql, user, email, hash, expiry)
+	return v, err
+}
+
+// LatestEmailVerification returns the most recent verification sent to the user
+func (db *DB) LatestEmailVerification(user uuid.UUID) (*EmailVerification, error) {
+	v := new(EmailVerification)
+	sql := "SELECT * FROM email_verifications WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1"
+	err := db.Get(v, sql, user)
+	return v, err
+}
+
+// VerifyEmail uses up the verification token matching the hash and makes its address the
+// user's verified email, returns an error if it does not exist, expired or was already used
+func (db *DB) VerifyEmail(hash []byte) (*User, error) {
+	u := new(User)
+	sql := `WITH v AS (
+		UPDATE email_verifications SET used_at = NOW() WHERE token_hash = $1 AND used_at IS NULL AND expired_at > NOW() RETURNING user_id, email
+	) UPDATE users SET email = v.email, email_verified_at = NOW() FROM v WHERE users.id = v.user_id RETURNING users.*`
+	err := db.Get(u, sql, hash)
+	return u, err
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GPGChallenge struct based on gpg_challenges table in database
+type GPGChallenge struct {
+	ID        uuid.UUID  `db:"id" json:"challenge_id"`
+	UserID    uuid.UUID  `db:"user_id" json:"-"`
+	Nonce     string     `db:"nonce" json:"challenge"`
+	UsedAt    *time.Time `db:"used_at" json:"-"`
+	ExpiredAt time.Time  `db:"expired_at" json:"expired_at"`
+	CreatedAt time.Time  `db:"created_at" json:"-"`
+}
+
+/////////////////////////////
+// GPG Challenge Functions //
+/////////////////////////////
+
+// InsertGPGChallenge stores a nonce the user has to sign before expiry
+func (db *DB) InsertGPGChallenge(user uuid.UUID, nonce string, expiry time.Time) (*GPGChallenge, error) {
+	c := new(GPGChallenge)
+	sql := "INSERT INTO gpg_challenges (user_id, nonce, expired_at) VALUES ($1, $2, $3) RETURNING *"
+	err := db.Get(c, sql, user, nonce, expiry)
+	return c, err
+}
+
+// UseGPGChallenge marks the challenge as used and returns it, returns an error if it
+// does not exist, expired or was already used
+func (db *DB) UseGPGChallenge(id uuid.UUID) (*GPGChallenge, error) {
+	c := new(GPGChallenge)
+	sql := "UPDATE gpg_challenges SET used_at = NOW() WHERE id = $1 AND used_at IS NULL AND expired_at > NOW() RETURNING *"
+	err := db.Get(c, sql, id)
+	return c, err
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrLastIdentity is returned for unlinking the only identity of a user without a password,
+// they could not log in anymore
+var ErrLastIdentity = errors.New("last identity of a user without a password")
+
+// UserIdentity struct based on user_identities table in database
+type UserIdentity struct {
+	ID        uuid.UUID `db:"id" json:"id"`
+	UserID    uuid.UUID `db:"user_id" json:"user_id"`
+	Issuer    string    `db:"issuer" json:"issuer"`
+	Subject   string    `db:"subject" json:"subject"`
+	Email     string    `db:"email" json:"email"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+/////////////////////////////
+// User Identity Functions //
+/////////////////////////////
+
+// InsertUserIdentity links the issuer's subject to the user
+func (db *DB) InsertUserIdentity(user uuid.UUID, issuer string, subject string, email string) (*UserIdentity, error) {
+	i := new(UserIdentity)
+	sql := "INSERT INTO user_identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4) RETURNING *"
+	err := db.Get(i, sql, user, issuer, subject, email)
+	return i, err
+}
+
+// GetUserIdentity returns the identity for the issuer's subject
+func (db *DB) GetUserIdentity(issuer string, subject string) (*UserIdentity, error) {
+	i := new(UserIdentity)
+	sql := "SELECT * FROM user_identities WHERE issuer = $1 AND subject = $2"
+	err := db.Get(i, sql, issuer, subject)
+	return i, err
+}
+
+// GetUserIdentitiesByUser returns the identities linked to the user
+func (db *DB) GetUserIdentitiesByUser(user uuid.UUID) (*[]UserIdentity, error) {
+	i := new([]UserIdentity)
+	sql := "SELECT * FROM user_identities WHERE user_id = $1 ORDER BY created_at"
+	err := db.Select(i, sql, user)
+	return i, err
+}
+
+// DeleteUserIdentity unlinks an identity only if it belongs to the given user, a user
+// without a password keeps their last identity (ErrLastIdentity)
+func (db *DB) DeleteUserIdentity(id uuid.UUID, user uuid.UUID) (*UserIdentity, error) {
+	i := new(UserIdentity)
+	tx, err := db.Beginx()
+	if err != nil {
+		return i, err
+	}
+	defer tx.Rollback()
+	// Locking the user serializes concurrent unlinks of their identities
+	var digest string
+	err = tx.Get(&digest, "SELECT digest FROM users WHERE id = $1 FOR UPDATE", user)
+	if err != nil {
+		return i, err
+	}
+	sql := "DELETE FROM user_identities WHERE id = $1 AND user_id = $2 RETURNING *"
+	err = tx.Get(i, sql, id, user)
+	if err != nil {
+		return i, err
+	}
+	if digest == "" {
+		var left int
+		err = tx.Get(&left, "SELECT COUNT(*) FROM user_identities WHERE user_id = $1", user)
+		if err != nil {
+			return i, err
+		}
+		if left == 0 {
+			return i, ErrLastIdentity
+		}
+	}
+	return i, tx.Commit()
+}
+
+// ProvisionUser creates a user with a verified email for the issuer's subject and links the identity
+func (db *DB) ProvisionUser(issuer string, subject string, uname string, digest []byte, role string, email string) (*User, error) {
+	u := new(User)
+	tx, err := db.Beginx()
+	if err != nil {
+		return u, err
+	}
+	defer tx.Rollback()
+	sql := "INSERT INTO users (uname, digest, role, email, email_verified_at, gpg_key) VALUES ($1, $2, $3, $4, NOW(), '') RETURNING *"
+	if err = tx.Get(u, sql, uname, digest, role, email); err != nil {
+		return u, err
+	}
+	sql = "INSERT INTO user_identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4)"
+	if _, err = tx.Exec(sql, u.ID, issuer, subject, email); err != nil {
+		return u, err
+	}
+	return u, tx.Commit()
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Invitation struct based on invitations table in database
+type Invitation struct {
+	ID         uuid.UUID  `db:"id" json:"id"`
+	InvitedBy  *uuid.UUID `db:"invited_by" json:"invited_by"`
+	Email      string     `db:"email" json:"email"`
+	Role       string     `db:"role" json:"role"`
+	TokenHash  []byte     `db:"token_hash" json:"-"`
+	UserID     *uuid.UUID `db:"user_id" json:"user_id,omitempty"`
+	AcceptedAt *time.Time `db:"accepted_at" json:"accepted_at,omitempty"`
+	RevokedAt  *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+	ExpiredAt  time.Time  `db:"expired_at" json:"expired_at"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+}
+
+// Status returns whether the invitation is pending, accepted, revoked or expired
+func (i *Invitation) Status() string {
+	switch {
+	case i.AcceptedAt != nil:
+		return "accepted"
+	case i.RevokedAt != nil:
+		return "revoked"
+	case !time.Now().Before(i.ExpiredAt):
+		return "expired"
+	}
+	return "pending"
+}
+
+//////////////////////////
+// Invitation Functions //
+//////////////////////////
+
+// InsertInvitation stores an invitation for email with the given role that is valid until expiry
+func (db *DB) InsertInvitation(invitedBy uuid.UUID, email string, role string, hash []byte, expiry time.Time) (*Invitation, error) {
+	i := new(Invitation)
+	sql := "INSERT INTO invitations (invited_by, email, role, token_hash, expired_at) VALUES ($1, $2, $3, $4, $5) RETURNING *"
+	err := db.Get(i, sql, invitedBy, email, role, hash, expiry)
+	return i, err
+}
+
+// GetInvitations returns invitations newest first
+func (db *DB) GetInvitations(start int, end int) (*[]Invitation, error) {
+	total := end - start
+	i := new([]Invitation)
+	sql := "SELECT * FROM invitations ORDER BY created_at DESC OFFSET $1 LIMIT $2"
+	err := db.Select(i, sql, start, total)
+	return i, err
+}
+
+// RevokeInvitation revokes the invitation if it was not accepted or revoked yet
+func (db *DB) RevokeInvitation(id uuid.UUID) (*Invitation, error) {
+	i := new(Invitation)
+	sql := "UPDATE invitations SET revoked_at = NOW() WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL RETURNING *"
+	err := db.Get(i, sql, id)
+	return i, err
+}
+
+// AcceptInvitation uses up the pending invitation matching the hash and creates the invited
+// user with a verified email, nothing changes if the uname is taken
+func (db *DB) AcceptInvitation(hash []byte, uname string, digest []byte, gpg string, fingerprint string) (*User, error) {
+	u := new(User)
+	i := new(Invitation)
+	tx, err := db.Beginx()
+	if err != nil {
+		return u, err
+	}
+	defer tx.Rollback()
+	sql := "UPDATE invitations SET accepted_at = NOW() WHERE token_hash = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expired_at > NOW() RETURNING *"
+	if err = tx.Get(i, sql, hash); err != nil {
+		return u, err
+	}
+	sql = "INSERT INTO users (uname, digest, role, email, email_verified_at, gpg_key, gpg_fingerprint) VALUES ($1, $2, $3, $4, NOW(), $5, $6) RETURNING *"
+	if err = tx.Get(u, sql, uname, digest, i.Role, i.Email, gpg, fingerprint); err != nil {
+		return u, err
+	}
+	if _, err = tx.Exec("UPDATE invitations SET user_id = $2 WHERE id = $1", i.ID, u.ID); err != nil {
+		return u, err
+	}
+	return u, tx.Commit()
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LoginAttempt struct based on login_attempts table in database
+type LoginAttempt struct {
+	ID        int64     `db:"id" json:"id"`
+	UserID    uuid.UUID `db:"user_id" json:"user_id"`
+	IPAddress string    `db:"ip_address" json:"ip_address"`
+	UserAgent string    `db:"user_agent" json:"user_agent"`
+	Succeeded bool      `db:"succeeded" json:"succeeded"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// LoginHistory tells whether a login comes from somewhere the user logged in from before
+type LoginHistory struct {
+	Logins     int  `db:"logins"`
+	KnownIP    bool `db:"known_ip"`
+	KnownAgent bool `db:"known_agent"`
+}
+
+/////////////////////////////
+// Login Attempt Functions //
+/////////////////////////////
+
+// InsertLoginAttempt records a login attempt for the user from the ip address and user agent
+func (db *DB) InsertLoginAttempt(user uuid.UUID, ip string, userAgent string, succeeded bool) (*LoginAttempt, error) {
+	a := new(LoginAttempt)
+	sql := "INSERT INTO login_attempts (user_id, ip_address, user_agent, succeeded) VALUES ($1, $2, $3, $4) RETURNING *"
+	err := db.Get(a, sql, user, ip, userAgent, succeeded)
+	return a, err
+}
+
+// GetLoginAttemptsByUser returns the user's login attempts newest first
+func (db *DB) GetLoginAttemptsByUser(user uuid.UUID, start int, end int) (*[]LoginAttempt, error) {
+	total := end - start
+	a := new([]LoginAttempt)
+	sql := "SELECT * FROM login_attempts WHERE user_id = $1 ORDER BY created_at DESC OFFSET $2 LIMIT $3"
+	err := db.Select(a, sql, user, start, total)
+	return a, err
+}
+
+// GetLoginHistory counts the user's successful logins and whether any of them came from the
+// same ip_root() or user agent
+func (db *DB) GetLoginHistory(user uuid.UUID, ip string, userAgent string) (*LoginHistory, error) {
+	h := new(LoginHistory)
+	sql := `SELECT COUNT(*) AS logins,
+		COALESCE(BOOL_OR(ip_root(ip_address) = ip_root($2)), false) AS known_ip,
+		COALESCE(BOOL_OR(user_agent = $3), false) AS known_agent
+		FROM login_attempts WHERE user_id = $1 AND succeeded`
+	err := db.Get(h, sql, user, ip, userAgent)
+	return h, err
+}
+
+// IncrementFailedLogins adds a failed login to the user's count and returns the user
+func (db *DB) IncrementFailedLogins(user uuid.UUID) (*User, error) {
+	u := new(User)
+	sql := "UPDATE users SET failed_logins = failed_logins + 1 WHERE id = $1 RETURNING *"
+	err := db.Get(u, sql, user)
+	return u, err
+}
+
+// LockUser blocks logins for the user until the given time
+func (db *DB) LockUser(user uuid.UUID, until time.Time) (*User, error) {
+	u := new(User)
+	sql := "UPDATE users SET locked_until = $2 WHERE id = $1 RETURNING *"
+	err := db.Get(u, sql, user, until)
+	return u, err
+}
+
+// UnlockUser clears the lock and the failed login count of the user
+func (db *DB) UnlockUser(user uuid.UUID) (*User, error) {
+	u := new(User)
+	sql := "UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = $1 RETURNING *"
+	err := db.Get(u, sql, user)
+	return u, err
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PasswordReset struct based on password_resets table in database
+type PasswordReset struct {
+	ID        uuid.UUID  `db:"id"`
+	UserID    uuid.UUID  `db:"user_id"`
+	TokenHash []byte     `db:"token_hash"`
+	UsedAt    *time.Time `db:"used_at"`
+	ExpiredAt time.Time  `db:"expired_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+//////////////////////////////
+// Password Reset Functions //
+//////////////////////////////
+
+// InsertPasswordReset stores the hash of a reset token for the user that is valid until expiry
+func (db *DB) InsertPasswordReset(user uuid.UUID, hash []byte, expiry time.Time) (*PasswordReset, error) {
+	p := new(PasswordReset)
+	sql := "INSERT INTO password_resets (user_id, token_hash, expired_at) VALUES ($1, $2, $3) RETURNING *"
+	err := db.Get(p, sql, user, hash, expiry)
+	return p, err
+}
+
+// UsePasswordReset marks the reset token matching the hash (and every other outstanding token
+// of the same user) as used, returns an error if it does not exist, expired or was already used
+func (db *DB) UsePasswordReset(hash []byte) (*PasswordReset, error) {
+	p := new(PasswordReset)
+	sql := `WITH reset AS (
+		UPDATE password_resets SET used_at = NOW() WHERE token_hash = $1 AND used_at IS NULL AND expired_at > NOW() RETURNING *
+	), others AS (
+		UPDATE password_resets SET used_at = NOW() WHERE user_id IN (SELECT user_id FROM reset) AND token_hash <> $1 AND used_at IS NULL
+	) SELECT * FROM reset`
+	err := db.Get(p, sql, hash)
+	return p, err
+}
+
+// UpdateUserDigest replaces the user's password digest
+func (db *DB) UpdateUserDigest(user uuid.UUID, digest []byte) (*User, error) {
+	u := new(User)
+	sql := "UPDATE users SET digest = $2 WHERE id = $1 RETURNING *"
+	err := db.Get(u, sql, user, digest)
+	return u, err
+}
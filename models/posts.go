@@ -8,17 +8,48 @@ import (
 
 // Post struct based on posts table in database
 type Post struct {
-	ID          uuid.UUID `db:"id" json:"id"`
-	UserID      uuid.UUID `db:"user_id" json:"user_id"`
-	Title       string    `db:"title" json:"title"`
-	Slug        string    `db:"slug" json:"slug"`
-	SubTitle    string    `db:"sub_title" json:"sub_title"`
-	Short       string    `db:"short" json:"short"`
-	PostContent string    `db:"post_content" json:"post_content"`
-	Digest      string    `db:"digest" json:"digest"`
-	Published   bool      `db:"published" json:"published"`
-	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
-	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	ID           uuid.UUID  `db:"id" json:"id"`
+	UserID       uuid.UUID  `db:"user_id" json:"user_id"`
+	Title        string     `db:"title" json:"title"`
+	Slug         string     `db:"slug" json:"slug"`
+	SubTitle     string     `db:"sub_title" json:"sub_title"`
+	Short        string     `db:"short" json:"short"`
+	SourceFormat string     `db:"source_format" json:"source_format"`
+	Source       string     `db:"source" json:"source"`
+	PostContent  string     `db:"post_content" json:"post_content"`
+	Digest       string     `db:"digest" json:"digest"`
+	Published    bool       `db:"published" json:"published"`
+	PublishAt    *time.Time `db:"publish_at" json:"publish_at"`
+	PublishedAt  *time.Time `db:"published_at" json:"published_at"`
+	UpdatedAt    time.Time  `db:"updated_at" json:"updated_at"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+}
+
+// postColumns are the columns a Post is read from. posts also has the generated search
+// document (PostgreSQL 12+) that only SearchPosts uses, so post queries list the columns
+// instead of selecting *
+const postColumns = `posts.id, posts.user_id, posts.title, posts.slug, posts.sub_title, posts.short,
+	posts.source_format, posts.source, posts.post_content, posts.digest, posts.published,
+	posts.publish_at, posts.published_at, posts.updated_at, posts.created_at`
+
+// PostFields are the values InsertPost and UpdatePost write, PostContent is the HTML
+// rendered from Source. The post's tags are replaced by Tags unless KeepTags is set and
+// the time PublishDuePosts publishes it is set to PublishAt (nil cancels) unless
+// KeepSchedule is set
+type PostFields struct {
+	Title        string
+	Slug         string
+	SubTitle     string
+	Short        string
+	SourceFormat string
+	Source       string
+	PostContent  string
+	Digest       string
+	Published    bool
+	Tags         []uuid.UUID
+	KeepTags     bool
+	PublishAt    *time.Time
+	KeepSchedule bool
 }
 
 // Image struct based on image table in database
@@ -48,16 +79,24 @@ type Tag struct {
 func (db *DB) PublishedPosts(start int, end int) (*[]Post, error) {
 	total := end - start
 	p := new([]Post)
-	sql := "SELECT * FROM posts WHERE published = true OFFSET $1 LIMIT $2"
-	err := db.Get(p, sql, start, total)
+	sql := "SELECT " + postColumns + " FROM posts WHERE published = true OFFSET $1 LIMIT $2"
+	err := db.Select(p, sql, start, total)
 	return p, err
 }
 
 // UnpublishedPosts returns all unpublished posts in database
 func (db *DB) UnpublishedPosts() (*[]Post, error) {
 	p := new([]Post)
-	sql := "SELECT * FROM posts WHERE published = false"
-	err := db.Get(p, sql)
+	sql := "SELECT " + postColumns + " FROM posts WHERE published = false"
+	err := db.Select(p, sql)
+	return p, err
+}
+
+// UnpublishedPostsByUser returns the unpublished posts written by the given user
+func (db *DB) UnpublishedPostsByUser(user uuid.UUID) (*[]Post, error) {
+	p := new([]Post)
+	sql := "SELECT " + postColumns + " FROM posts WHERE published = false AND user_id = $1"
+	err := db.Select(p, sql, user)
 	return p, err
 }
 
@@ -65,7 +104,7 @@ func (db *DB) UnpublishedPosts() (*[]Post, error) {
 func (db *DB) GetPosts(start int, end int) (*[]Post, error) {
 	total := end - start
 	p := new([]Post)
-	sql := "SELECT * FROM posts OFFSET $1 LIMIT $2"
+	sql := "SELECT " + postColumns + " FROM posts OFFSET $1 LIMIT $2"
 	err := db.Select(p, sql, start, total)
 	return p, err
 }
@@ -73,40 +112,128 @@ func (db *DB) GetPosts(start int, end int) (*[]Post, error) {
 // FindPost returns the post that matches the uuid
 func (db *DB) FindPost(id uuid.UUID) (*Post, error) {
 	p := new(Post)
-	sql := "SELECT * FROM posts WHERE id = $1"
+	sql := "SELECT " + postColumns + " FROM posts WHERE id = $1"
 	err := db.Get(p, sql, id)
 	return p, err
 }
 
+// FindPostBySlug returns the post with the slug
+func (db *DB) FindPostBySlug(slug string) (*Post, error) {
+	p := new(Post)
+	sql := "SELECT " + postColumns + " FROM posts WHERE slug = $1"
+	err := db.Get(p, sql, slug)
+	return p, err
+}
+
+// FindSlugRedirect returns the post that used to have the slug
+func (db *DB) FindSlugRedirect(slug string) (*Post, error) {
+	p := new(Post)
+	sql := "SELECT " + postColumns + " FROM posts JOIN slug_redirects ON slug_redirects.post_id = posts.id WHERE slug_redirects.slug = $1"
+	err := db.Get(p, sql, slug)
+	return p, err
+}
+
 // FindPostsByUser returns a slice of posts created by the given user
 func (db *DB) FindPostsByUser(user uuid.UUID) (*[]Post, error) {
 	p := new([]Post)
-	sql := "SELECT * FROM posts WHERE user_id = $1"
+	sql := "SELECT " + postColumns + " FROM posts WHERE user_id = $1"
 	err := db.Select(p, sql, user)
 	return p, err
 }
 
-// InsertPost creates a post for the given user and returns the post
-func (db *DB) InsertPost(user uuid.UUID, title string, slug string, subtitle string, short string, content string, digest string, published bool) (*Post, error) {
+// InsertPost creates a post for the given user with its tags and returns the post
+func (db *DB) InsertPost(user uuid.UUID, f *PostFields) (*Post, error) {
 	p := new(Post)
-	sql := "INSERT INTO posts (user_id, title, slug, subtitle, short, content, digest, published) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING *"
-	err := db.Get(p, sql, user, title, slug, subtitle, short, content, digest, published)
-	return p, err
+	tx, err := db.Beginx()
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback()
+	sql := `INSERT INTO posts (user_id, title, slug, sub_title, short, source_format, source, post_content, digest, published, published_at, publish_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, CASE WHEN $10 THEN NOW() END, $11) RETURNING ` + postColumns
+	err = tx.Get(p, sql, user, f.Title, f.Slug, f.SubTitle, f.Short, f.SourceFormat, f.Source, f.PostContent, f.Digest, f.Published, f.PublishAt)
+	if err != nil {
+		return p, err
+	}
+	err = setPostTags(tx, p.ID, f.Tags)
+	if err != nil {
+		return p, err
+	}
+	return p, tx.Commit()
 }
 
-// UpdatePost updates a post in the database and returns the updated image
-func (db *DB) UpdatePost(id uuid.UUID, user uuid.UUID, title string, slug string, subtitle string, short string, content string, digest string, published bool) (*Post, error) {
+// UpdatePost updates a post (and its tags) in the database and returns the updated post,
+// the previous version is kept as a revision and the old slug redirects to the post when
+// it changes. Publishing sets published_at and cancels a scheduled publish, unpublishing
+// clears it
+func (db *DB) UpdatePost(id uuid.UUID, f *PostFields) (*Post, error) {
 	p := new(Post)
-	sql := "UPDATE posts SET (user_id, title, slug, subtitle, short, content, digest, published) VALUES ($2, $3, $4, $5, $6, $7, $8) WHERE id = $1 RETURNING *"
-	err := db.Get(p, sql, id, user, title, slug, subtitle, short, content, digest, published)
+	tx, err := db.Beginx()
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback()
+	var old string
+	err = tx.Get(&old, "SELECT slug FROM posts WHERE id = $1 FOR UPDATE", id)
+	if err != nil {
+		return p, err
+	}
+	sql := `INSERT INTO post_revisions (post_id, revision, title, slug, sub_title, short, source_format, source, post_content, digest, published, updated_at)
+		SELECT id, COALESCE((SELECT MAX(revision) FROM post_revisions WHERE post_id = $1), 0) + 1,
+		title, slug, sub_title, short, source_format, source, post_content, digest, COALESCE(published, false), updated_at FROM posts WHERE id = $1`
+	_, err = tx.Exec(sql, id)
+	if err != nil {
+		return p, err
+	}
+	sql = `UPDATE posts SET (title, slug, sub_title, short, source_format, source, post_content, digest, published, updated_at) = ($2, $3, $4, $5, $6, $7, $8, $9, $10, NOW()),
+		published_at = CASE WHEN NOT $10 THEN NULL WHEN published THEN published_at ELSE NOW() END,
+		publish_at = CASE WHEN $10 THEN NULL WHEN $12 THEN publish_at ELSE $11 END
+		WHERE id = $1 RETURNING ` + postColumns
+	err = tx.Get(p, sql, id, f.Title, f.Slug, f.SubTitle, f.Short, f.SourceFormat, f.Source, f.PostContent, f.Digest, f.Published, f.PublishAt, f.KeepSchedule)
+	if err != nil {
+		return p, err
+	}
+	if old != f.Slug {
+		// The new slug is live again if it was redirected before
+		_, err = tx.Exec("DELETE FROM slug_redirects WHERE slug = $1", f.Slug)
+		if err != nil {
+			return p, err
+		}
+		sql = "INSERT INTO slug_redirects (slug, post_id) VALUES ($1, $2) ON CONFLICT (slug) DO UPDATE SET post_id = $2, created_at = NOW()"
+		_, err = tx.Exec(sql, old, id)
+		if err != nil {
+			return p, err
+		}
+	}
+	if !f.KeepTags {
+		err = setPostTags(tx, id, f.Tags)
+		if err != nil {
+			return p, err
+		}
+	}
+	return p, tx.Commit()
+}
+
+// PublishDuePosts publishes up to limit unpublished posts whose publish_at has passed and
+// returns them, published_at is the scheduled time. Rows locked by another instance
+// doing the same are skipped so every post is published once
+func (db *DB) PublishDuePosts(limit int) (*[]Post, error) {
+	p := new([]Post)
+	sql := `WITH due AS (
+		SELECT id FROM posts WHERE NOT published AND publish_at <= NOW()
+		ORDER BY publish_at LIMIT $1 FOR UPDATE SKIP LOCKED
+	)
+	UPDATE posts SET published = true, published_at = posts.publish_at, publish_at = NULL, updated_at = NOW()
+	FROM due WHERE posts.id = due.id RETURNING ` + postColumns
+	err := db.Select(p, sql, limit)
 	return p, err
 }
 
-// DeletePost deletes and returns the image from the database that matches the uuid
-func (db *DB) DeletePost(id uuid.UUID, user uuid.UUID) (*Post, error) {
+// DeletePost deletes and returns the post from the database that matches the uuid
+func (db *DB) DeletePost(id uuid.UUID) (*Post, error) {
 	p := new(Post)
-	sql := "DELETE FROM posts WHERE id = $1 AND user_id = $2 RETURNING *"
-	err := db.Get(p, sql, id, user)
+	sql := "DELETE FROM posts WHERE id = $1 RETURNING " + postColumns
+	err := db.Get(p, sql, id)
 	return p, err
 }
 
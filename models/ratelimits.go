@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+// attemptCount is the result of counting ratelimits rows
+type attemptCount struct {
+	Count  int        `db:"count"`
+	Oldest *time.Time `db:"oldest"`
+}
+
+//////////////////////////
+// Rate Limit Functions //
+//////////////////////////
+
+// RecordAttempt inserts an attempt for the given bucket (route) and ip address
+func (db *DB) RecordAttempt(bucket string, ip string) error {
+	sql := "INSERT INTO ratelimits (bucket, ip_address) VALUES ($1, $2)"
+	_, err := db.Exec(sql, bucket, ip)
+	return err
+}
+
+// CountAttempts returns the number of attempts made from the ip_root() of the address since
+// the given time along with the time of the oldest of those attempts
+func (db *DB) CountAttempts(bucket string, ip string, since time.Time) (int, time.Time, error) {
+	c := new(attemptCount)
+	sql := "SELECT COUNT(*) AS count, MIN(created_at) AS oldest FROM ratelimits WHERE bucket = $1 AND ip_root(ip_address) = ip_root($2) AND created_at > $3"
+	err := db.Get(c, sql, bucket, ip, since)
+	if err != nil || c.Oldest == nil {
+		return c.Count, time.Time{}, err
+	}
+	return c.Count, *c.Oldest, nil
+}
+
+// PruneAttempts deletes every attempt older than before and returns how many were deleted
+func (db *DB) PruneAttempts(before time.Time) (int64, error) {
+	sql := "DELETE FROM ratelimits WHERE created_at < $1"
+	res, err := db.Exec(sql, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshToken struct based on refresh_tokens table in database
+type RefreshToken struct {
+	ID        uuid.UUID  `db:"id" json:"id"`
+	SessionID uuid.UUID  `db:"session_id" json:"session_id"`
+	TokenHash []byte     `db:"token_hash" json:"-"`
+	UsedAt    *time.Time `db:"used_at" json:"used_at,omitempty"`
+	ExpiredAt time.Time  `db:"expired_at" json:"expired_at"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+}
+
+/////////////////////////////
+// Refresh Token Functions //
+/////////////////////////////
+
+// InsertRefreshToken stores the hash of a new refresh token for the session
+func (db *DB) InsertRefreshToken(session uuid.UUID, hash []byte, expiry time.Time) (*RefreshToken, error) {
+	t := new(RefreshToken)
+	sql := "INSERT INTO refresh_tokens (session_id, token_hash, expired_at) VALUES ($1, $2, $3) RETURNING *"
+	err := db.Get(t, sql, session, hash, expiry)
+	return t, err
+}
+
+// UseRefreshToken marks the refresh token as used and returns it, returns an error if the
+// token does not exist, has expired or has already been used
+func (db *DB) UseRefreshToken(hash []byte) (*RefreshToken, error) {
+	t := new(RefreshToken)
+	sql := "UPDATE refresh_tokens SET used_at = NOW() WHERE token_hash = $1 AND used_at IS NULL AND expired_at > NOW() RETURNING *"
+	err := db.Get(t, sql, hash)
+	return t, err
+}
+
+// FindRefreshToken returns the refresh token that matches the hash whether or not it was used
+func (db *DB) FindRefreshToken(hash []byte) (*RefreshToken, error) {
+	t := new(RefreshToken)
+	sql := "SELECT * FROM refresh_tokens WHERE token_hash = $1"
+	err := db.Get(t, sql, hash)
+	return t, err
+}
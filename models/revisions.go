@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PostRevision struct based on post_revisions table in database, UpdatedAt is when the
+// snapshotted version was saved and CreatedAt when it was replaced
+type PostRevision struct {
+	ID           uuid.UUID `db:"id" json:"id"`
+	PostID       uuid.UUID `db:"post_id" json:"post_id"`
+	Revision     int       `db:"revision" json:"revision"`
+	Title        string    `db:"title" json:"title"`
+	Slug         string    `db:"slug" json:"slug"`
+	SubTitle     string    `db:"sub_title" json:"sub_title"`
+	Short        string    `db:"short" json:"short"`
+	SourceFormat string    `db:"source_format" json:"source_format"`
+	Source       string    `db:"source" json:"source"`
+	PostContent  string    `db:"post_content" json:"post_content"`
+	Digest       string    `db:"digest" json:"digest"`
+	Published    bool      `db:"published" json:"published"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
+
+/////////////////////////////
+// Post Revision Functions //
+/////////////////////////////
+
+// GetPostRevisions returns the revisions of a post, newest first
+func (db *DB) GetPostRevisions(post uuid.UUID) (*[]PostRevision, error) {
+	r := new([]PostRevision)
+	sql := "SELECT * FROM post_revisions WHERE post_id = $1 ORDER BY revision DESC"
+	err := db.Select(r, sql, post)
+	return r, err
+}
+
+// FindPostRevision returns the numbered revision of a post
+func (db *DB) FindPostRevision(post uuid.UUID, revision int) (*PostRevision, error) {
+	r := new(PostRevision)
+	sql := "SELECT * FROM post_revisions WHERE post_id = $1 AND revision = $2"
+	err := db.Get(r, sql, post, revision)
+	return r, err
+}
+
+// PrunePostRevisions deletes all but the newest keep revisions of a post (keep 0 keeps all)
+// and the ones replaced before the given time, returns the number of revisions deleted
+func (db *DB) PrunePostRevisions(post uuid.UUID, keep int, before time.Time) (int64, error) {
+	sql := `DELETE FROM post_revisions WHERE post_id = $1 AND (created_at < $3 OR ($2 > 0 AND revision <=
+		(SELECT MAX(revision) FROM post_revisions WHERE post_id = $1) - $2))`
+	res, err := db.Exec(sql, post, keep, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
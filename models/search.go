@@ -0,0 +1,41 @@
+package models
+
+// SearchFilter narrows down SearchPosts, zero values match every post
+type SearchFilter struct {
+	Query     string
+	Tag       string
+	Published *bool
+	Start     int
+	End       int
+}
+
+// SearchResult is a post matching a search with its rank and a snippet of the content with
+// the matches in <mark> tags
+type SearchResult struct {
+	Post
+	Rank    float64 `db:"rank" json:"rank"`
+	Snippet string  `db:"snippet" json:"snippet"`
+}
+
+//////////////////////
+// Search Functions //
+//////////////////////
+
+// SearchPosts returns the posts matching the websearch_to_tsquery syntax query (quoted
+// phrases, or, -word) best match first, optionally only those with the tag slug or
+// published state
+func (db *DB) SearchPosts(f SearchFilter) (*[]SearchResult, error) {
+	total := f.End - f.Start
+	s := new([]SearchResult)
+	sql := `SELECT ` + postColumns + `, ts_rank(posts.search, query) AS rank,
+		ts_headline('english', regexp_replace(posts.post_content, '<[^>]*>', ' ', 'g'), query,
+			'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10') AS snippet
+		FROM posts, websearch_to_tsquery('english', $1) query
+		WHERE posts.search @@ query
+		AND ($2 = '' OR EXISTS (SELECT 1 FROM posts_tags JOIN tags ON tags.id = posts_tags.tag_id
+			WHERE posts_tags.post_id = posts.id AND tags.slug = $2))
+		AND ($3::boolean IS NULL OR posts.published = $3)
+		ORDER BY rank DESC, posts.created_at DESC OFFSET $4 LIMIT $5`
+	err := db.Select(s, sql, f.Query, f.Tag, f.Published, f.Start, total)
+	return s, err
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session struct based on sessions table in database
+type Session struct {
+	ID          uuid.UUID  `db:"id" json:"id"`
+	UserID      uuid.UUID  `db:"user_id" json:"user_id"`
+	IPAddress   string     `db:"ip_address" json:"ip_address"`
+	UserAgent   string     `db:"user_agent" json:"user_agent"`
+	LoggedOutAt *time.Time `db:"logged_out_at" json:"logged_out_at,omitempty"`
+	ExpiredAt   time.Time  `db:"expired_at" json:"expired_at"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+}
+
+///////////////////////
+// Session Functions //
+///////////////////////
+
+// CreateSession inserts a new session for the given user that is valid until expiry
+func (db *DB) CreateSession(user uuid.UUID, ip string, userAgent string, expiry time.Time) (*Session, error) {
+	s := new(Session)
+	sql := "INSERT INTO sessions (id, user_id, ip_address, user_agent, expired_at) VALUES ($1, $2, $3, $4, $5) RETURNING *"
+	err := db.Get(s, sql, uuid.New(), user, ip, userAgent, expiry)
+	return s, err
+}
+
+// GetActiveSession returns the session that matches the uuid as long as it has
+// not expired or been logged out
+func (db *DB) GetActiveSession(id uuid.UUID) (*Session, error) {
+	s := new(Session)
+	sql := "SELECT * FROM active_sessions WHERE id = $1"
+	err := db.Get(s, sql, id)
+	return s, err
+}
+
+// RevokeSession marks the session as logged out so it can no longer be used
+func (db *DB) RevokeSession(id uuid.UUID) (*Session, error) {
+	s := new(Session)
+	sql := "UPDATE sessions SET logged_out_at = NOW() WHERE id = $1 AND logged_out_at IS NULL RETURNING *"
+	err := db.Get(s, sql, id)
+	return s, err
+}
+
+// GetActiveSessionsByUser returns every session for the given user that is still usable
+func (db *DB) GetActiveSessionsByUser(user uuid.UUID) (*[]Session, error) {
+	s := new([]Session)
+	sql := "SELECT * FROM active_sessions WHERE user_id = $1 ORDER BY created_at DESC"
+	err := db.Select(s, sql, user)
+	return s, err
+}
+
+// RevokeUserSession marks a session as logged out only if it belongs to the given user
+func (db *DB) RevokeUserSession(id uuid.UUID, user uuid.UUID) (*Session, error) {
+	s := new(Session)
+	sql := "UPDATE sessions SET logged_out_at = NOW() WHERE id = $1 AND user_id = $2 AND logged_out_at IS NULL RETURNING *"
+	err := db.Get(s, sql, id, user)
+	return s, err
+}
+
+// RevokeUserSessions marks every active session of the given user as logged out except
+// the session matching except (pass uuid.Nil to revoke all of them)
+func (db *DB) RevokeUserSessions(user uuid.UUID, except uuid.UUID) (*[]Session, error) {
+	s := new([]Session)
+	sql := "UPDATE sessions SET logged_out_at = NOW() WHERE user_id = $1 AND id <> $2 AND logged_out_at IS NULL RETURNING *"
+	err := db.Select(s, sql, user, except)
+	return s, err
+}
+
+// GetSessionsByUser returns every session of the given user including expired and logged out ones
+func (db *DB) GetSessionsByUser(user uuid.UUID) (*[]Session, error) {
+	s := new([]Session)
+	sql := "SELECT * FROM sessions WHERE user_id = $1 ORDER BY created_at DESC"
+	err := db.Select(s, sql, user)
+	return s, err
+}
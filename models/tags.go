@@ -0,0 +1,112 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// TagCount is a tag with the number of posts carrying it
+type TagCount struct {
+	Tag
+	Posts int `db:"posts" json:"posts"`
+}
+
+///////////////////
+// Tag Functions //
+///////////////////
+
+// GetTags returns every tag by name with the number of posts tagged, only published posts
+// are counted when published is true
+func (db *DB) GetTags(published bool) (*[]TagCount, error) {
+	t := new([]TagCount)
+	sql := `SELECT tags.*, COUNT(posts.id) AS posts FROM tags
+		LEFT JOIN posts_tags ON posts_tags.tag_id = tags.id
+		LEFT JOIN posts ON posts.id = posts_tags.post_id AND (posts.published OR NOT $1)
+		GROUP BY tags.id ORDER BY tags.name`
+	err := db.Select(t, sql, published)
+	return t, err
+}
+
+// FindTag returns the tag that matches the uuid
+func (db *DB) FindTag(id uuid.UUID) (*Tag, error) {
+	t := new(Tag)
+	sql := "SELECT * FROM tags WHERE id = $1"
+	err := db.Get(t, sql, id)
+	return t, err
+}
+
+// FindTagBySlug returns the tag that matches the slug
+func (db *DB) FindTagBySlug(slug string) (*Tag, error) {
+	t := new(Tag)
+	sql := "SELECT * FROM tags WHERE slug = $1"
+	err := db.Get(t, sql, slug)
+	return t, err
+}
+
+// FindTagsBySlugs returns the tags matching any of the slugs, unknown slugs are left out
+func (db *DB) FindTagsBySlugs(slugs []string) (*[]Tag, error) {
+	t := new([]Tag)
+	sql := "SELECT * FROM tags WHERE slug = ANY($1) ORDER BY name"
+	err := db.Select(t, sql, pq.Array(slugs))
+	return t, err
+}
+
+// InsertTag creates a tag and returns it
+func (db *DB) InsertTag(name string, slug string) (*Tag, error) {
+	t := new(Tag)
+	sql := "INSERT INTO tags (name, slug) VALUES ($1, $2) RETURNING *"
+	err := db.Get(t, sql, name, slug)
+	return t, err
+}
+
+// UpdateTag renames a tag and returns the updated tag
+func (db *DB) UpdateTag(id uuid.UUID, name string, slug string) (*Tag, error) {
+	t := new(Tag)
+	sql := "UPDATE tags SET (name, slug) = ($2, $3) WHERE id = $1 RETURNING *"
+	err := db.Get(t, sql, id, name, slug)
+	return t, err
+}
+
+// DeleteTag deletes and returns the tag, it is removed from every post
+func (db *DB) DeleteTag(id uuid.UUID) (*Tag, error) {
+	t := new(Tag)
+	sql := "DELETE FROM tags WHERE id = $1 RETURNING *"
+	err := db.Get(t, sql, id)
+	return t, err
+}
+
+// GetPostTags returns the tags on a post
+func (db *DB) GetPostTags(post uuid.UUID) (*[]Tag, error) {
+	t := new([]Tag)
+	sql := `SELECT tags.* FROM tags JOIN posts_tags ON posts_tags.tag_id = tags.id
+		WHERE posts_tags.post_id = $1 ORDER BY tags.name`
+	err := db.Select(t, sql, post)
+	return t, err
+}
+
+// setPostTags replaces the tags on a post as part of the post's transaction
+func setPostTags(tx *sqlx.Tx, post uuid.UUID, tags []uuid.UUID) error {
+	ids := make([]string, 0, len(tags))
+	for _, id := range tags {
+		ids = append(ids, id.String())
+	}
+	_, err := tx.Exec("DELETE FROM posts_tags WHERE post_id = $1", post)
+	if err != nil {
+		return err
+	}
+	sql := "INSERT INTO posts_tags (post_id, tag_id) SELECT $1, unnest($2::uuid[]) ON CONFLICT DO NOTHING"
+	_, err = tx.Exec(sql, post, pq.Array(ids))
+	return err
+}
+
+// PublishedPostsByTag returns the published posts carrying the tag, newest first
+func (db *DB) PublishedPostsByTag(tag uuid.UUID, start int, end int) (*[]Post, error) {
+	total := end - start
+	p := new([]Post)
+	sql := `SELECT ` + postColumns + ` FROM posts JOIN posts_tags ON posts_tags.post_id = posts.id
+		WHERE posts_tags.tag_id = $1 AND posts.published = true
+		ORDER BY posts.created_at DESC OFFSET $2 LIMIT $3`
+	err := db.Select(p, sql, tag, start, total)
+	return p, err
+}
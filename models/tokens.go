@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// APIToken struct based on api_tokens table in database
+type APIToken struct {
+	ID         uuid.UUID      `db:"id" json:"id"`
+	UserID     uuid.UUID      `db:"user_id" json:"user_id"`
+	Name       string         `db:"name" json:"name"`
+	TokenHash  []byte         `db:"token_hash" json:"-"`
+	Scopes     pq.StringArray `db:"scopes" json:"scopes"`
+	LastUsedAt *time.Time     `db:"last_used_at" json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time     `db:"revoked_at" json:"revoked_at,omitempty"`
+	ExpiredAt  *time.Time     `db:"expired_at" json:"expired_at,omitempty"`
+	CreatedAt  time.Time      `db:"created_at" json:"created_at"`
+}
+
+/////////////////////////
+// API Token Functions //
+/////////////////////////
+
+// InsertAPIToken stores the hash of a new token for the user, expiry may be nil for tokens that never expire
+func (db *DB) InsertAPIToken(user uuid.UUID, name string, hash []byte, scopes []string, expiry *time.Time) (*APIToken, error) {
+	t := new(APIToken)
+	sql := "INSERT INTO api_tokens (user_id, name, token_hash, scopes, expired_at) VALUES ($1, $2, $3, $4, $5) RETURNING *"
+	err := db.Get(t, sql, user, name, hash, pq.StringArray(scopes), expiry)
+	return t, err
+}
+
+// GetActiveAPIToken returns the token that matches the hash if it has not been revoked or expired
+func (db *DB) GetActiveAPIToken(hash []byte) (*APIToken, error) {
+	t := new(APIToken)
+	sql := "SELECT * FROM active_api_tokens WHERE token_hash = $1"
+	err := db.Get(t, sql, hash)
+	return t, err
+}
+
+// GetAPITokensByUser returns the user's tokens that have not been revoked or expired
+func (db *DB) GetAPITokensByUser(user uuid.UUID) (*[]APIToken, error) {
+	t := new([]APIToken)
+	sql := "SELECT * FROM active_api_tokens WHERE user_id = $1 ORDER BY created_at DESC"
+	err := db.Select(t, sql, user)
+	return t, err
+}
+
+// RevokeAPIToken revokes a token only if it belongs to the given user
+func (db *DB) RevokeAPIToken(id uuid.UUID, user uuid.UUID) (*APIToken, error) {
+	t := new(APIToken)
+	sql := "UPDATE api_tokens SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL RETURNING *"
+	err := db.Get(t, sql, id, user)
+	return t, err
+}
+
+// TouchAPIToken records that the token was just used
+func (db *DB) TouchAPIToken(id uuid.UUID) error {
+	sql := "UPDATE api_tokens SET last_used_at = NOW() WHERE id = $1"
+	_, err := db.Exec(sql, id)
+	return err
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+//////////////////////////
+// Two Factor Functions //
+//////////////////////////
+
+// SetTOTPSecret stores a pending secret for the user, it is not used until EnableTOTP
+func (db *DB) SetTOTPSecret(user uuid.UUID, secret string) (*User, error) {
+	u := new(User)
+	sql := "UPDATE users SET totp_secret = $2 WHERE id = $1 AND totp_enabled = false RETURNING *"
+	err := db.Get(u, sql, user, secret)
+	return u, err
+}
+
+// EnableTOTP turns on two factor authentication with the pending secret and replaces
+// the user's recovery codes with the given hashes (also used to regenerate recovery codes)
+func (db *DB) EnableTOTP(user uuid.UUID, step int64, recoveryHashes [][]byte) (*User, error) {
+	u := new(User)
+	tx, err := db.Beginx()
+	if err != nil {
+		return u, err
+	}
+	defer tx.Rollback()
+	sql := "UPDATE users SET totp_enabled = true, totp_last_step = GREATEST(totp_last_step, $2) WHERE id = $1 AND totp_secret IS NOT NULL RETURNING *"
+	if err = tx.Get(u, sql, user, step); err != nil {
+		return u, err
+	}
+	if _, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", user); err != nil {
+		return u, err
+	}
+	for _, h := range recoveryHashes {
+		if _, err = tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", user, h); err != nil {
+			return u, err
+		}
+	}
+	return u, tx.Commit()
+}
+
+// DisableTOTP turns off two factor authentication and removes the secret and recovery codes
+func (db *DB) DisableTOTP(user uuid.UUID) (*User, error) {
+	u := new(User)
+	tx, err := db.Beginx()
+	if err != nil {
+		return u, err
+	}
+	defer tx.Rollback()
+	sql := "UPDATE users SET totp_enabled = false, totp_secret = NULL, totp_last_step = 0 WHERE id = $1 RETURNING *"
+	if err = tx.Get(u, sql, user); err != nil {
+		return u, err
+	}
+	if _, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", user); err != nil {
+		return u, err
+	}
+	return u, tx.Commit()
+}
+
+// UseTOTPStep records the time step of an accepted code, returns an error if the step
+// (or a later one) was already used so codes cannot be replayed
+func (db *DB) UseTOTPStep(user uuid.UUID, step int64) (*User, error) {
+	u := new(User)
+	sql := "UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2 RETURNING *"
+	err := db.Get(u, sql, user, step)
+	return u, err
+}
+
+// UseRecoveryCode marks the user's unused recovery code matching the hash as used,
+// returns an error if there is no such code
+func (db *DB) UseRecoveryCode(user uuid.UUID, hash []byte) error {
+	var id uuid.UUID
+	sql := "UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL RETURNING id"
+	return db.Get(&id, sql, user, hash)
+}
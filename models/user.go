@@ -8,28 +8,58 @@ import (
 
 // User struct based on users table in database
 type User struct {
-	ID           uuid.UUID `db:"id"`
-	Uname        string    `db:"uname"`
-	Digest       []byte    `db:"digest"`
-	Role         string    `db:"role"`
-	Email        string    `db:"email"`
-	GpgKey       string    `db:"email"`
-	LastOnlineAt time.Time `db:"updated_at"`
-	CreatedAt    time.Time `db:"created_at"`
+	ID              uuid.UUID  `db:"id" json:"id"`
+	Uname           string     `db:"uname" json:"uname"`
+	Digest          []byte     `db:"digest" json:"-"`
+	Role            string     `db:"role" json:"role"`
+	Email           string     `db:"email" json:"email"`
+	EmailVerifiedAt *time.Time `db:"email_verified_at" json:"email_verified_at,omitempty"`
+	GpgKey          string     `db:"gpg_key" json:"gpg_key"`
+	GpgFingerprint  string     `db:"gpg_fingerprint" json:"gpg_fingerprint"`
+	LastOnlineAt    time.Time  `db:"last_online_at" json:"last_online_at"`
+	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
+	TOTPSecret      *string    `db:"totp_secret" json:"-"`
+	TOTPEnabled     bool       `db:"totp_enabled" json:"totp_enabled"`
+	TOTPLastStep    int64      `db:"totp_last_step" json:"-"`
+	FailedLogins    int        `db:"failed_logins" json:"failed_logins"`
+	LockedUntil     *time.Time `db:"locked_until" json:"locked_until,omitempty"`
+	DisabledAt      *time.Time `db:"disabled_at" json:"disabled_at,omitempty"`
+}
+
+// UserView is the public profile of a user, it is safe to show to anyone
+type UserView struct {
+	ID             uuid.UUID `json:"id"`
+	Uname          string    `json:"uname"`
+	Role           string    `json:"role"`
+	GpgKey         string    `json:"gpg_key"`
+	GpgFingerprint string    `json:"gpg_fingerprint"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// View returns the public profile of the user
+func (u *User) View() UserView {
+	return UserView{
+		ID:             u.ID,
+		Uname:          u.Uname,
+		Role:           u.Role,
+		GpgKey:         u.GpgKey,
+		GpgFingerprint: u.GpgFingerprint,
+		CreatedAt:      u.CreatedAt,
+	}
 }
 
 // InsertUser ...
-func (db *DB) InsertUser(uname string, digest []byte, role string, email string, gpg string) (*User, error) {
+func (db *DB) InsertUser(uname string, digest []byte, role string, email string, gpg string, fingerprint string) (*User, error) {
 	u := new(User)
-	sql := "INSERT INTO users (uname, digest, role, email, gpg) VALUES ($1, $2, $3, $4, $5) RETURNING *"
-	err := db.Get(u, sql, uname, digest, role, email, gpg)
+	sql := "INSERT INTO users (uname, digest, role, email, gpg_key, gpg_fingerprint) VALUES ($1, $2, $3, $4, $5, $6) RETURNING *"
+	err := db.Get(u, sql, uname, digest, role, email, gpg, fingerprint)
 	return u, err
 }
 
 // GetUserByEmail ...
 func (db *DB) GetUserByEmail(email string) (*User, error) {
 	u := new(User)
-	sql := "SELECT * FROM users WHERE email = $1"
+	sql := "SELECT * FROM users WHERE lower(email) = lower($1)"
 	err := db.Get(u, sql, email)
 	return u, err
 }
@@ -45,7 +75,62 @@ func (db *DB) GetUserByID(user uuid.UUID) (*User, error) {
 // GetUserByUname ...
 func (db *DB) GetUserByUname(uname string) (*User, error) {
 	u := new(User)
-	sql := "SELECT * FROM users WHERE uname = $1"
+	sql := "SELECT * FROM users WHERE lower(uname) = lower($1)"
 	err := db.Get(u, sql, uname)
 	return u, err
 }
+
+// UpdateUser replaces the digest and gpg key of the user, the email address only changes
+// through VerifyEmail
+func (db *DB) UpdateUser(user uuid.UUID, digest []byte, gpg string, fingerprint string) (*User, error) {
+	u := new(User)
+	sql := "UPDATE users SET digest = $2, gpg_key = $3, gpg_fingerprint = $4 WHERE id = $1 RETURNING *"
+	err := db.Get(u, sql, user, digest, gpg, fingerprint)
+	return u, err
+}
+
+// ListUsers returns users ordered by uname
+func (db *DB) ListUsers(start int, end int) (*[]User, error) {
+	total := end - start
+	u := new([]User)
+	sql := "SELECT * FROM users ORDER BY uname OFFSET $1 LIMIT $2"
+	err := db.Select(u, sql, start, total)
+	return u, err
+}
+
+// SetRole changes the role of the user
+func (db *DB) SetRole(user uuid.UUID, role string) (*User, error) {
+	u := new(User)
+	sql := "UPDATE users SET role = $2 WHERE id = $1 RETURNING *"
+	err := db.Get(u, sql, user, role)
+	return u, err
+}
+
+// DisableUser marks the user as disabled (or enabled again when disabled is false)
+func (db *DB) DisableUser(user uuid.UUID, disabled bool) (*User, error) {
+	u := new(User)
+	sql := "UPDATE users SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, NOW()) END WHERE id = $1 RETURNING *"
+	err := db.Get(u, sql, user, disabled)
+	return u, err
+}
+
+// DeleteUser hands the user's posts and images to heir and deletes the user along with
+// everything else they own (sessions, tokens, login attempts...)
+func (db *DB) DeleteUser(user uuid.UUID, heir uuid.UUID) (*User, error) {
+	u := new(User)
+	tx, err := db.Beginx()
+	if err != nil {
+		return u, err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("UPDATE posts SET user_id = $2 WHERE user_id = $1", user, heir); err != nil {
+		return u, err
+	}
+	if _, err = tx.Exec("UPDATE images SET user_id = $2 WHERE user_id = $1", user, heir); err != nil {
+		return u, err
+	}
+	if err = tx.Get(u, "DELETE FROM users WHERE id = $1 RETURNING *", user); err != nil {
+		return u, err
+	}
+	return u, tx.Commit()
+}
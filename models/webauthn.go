@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// WebAuthnCredential struct based on webauthn_credentials table in database
+type WebAuthnCredential struct {
+	ID              uuid.UUID      `db:"id" json:"id"`
+	UserID          uuid.UUID      `db:"user_id" json:"user_id"`
+	Name            string         `db:"name" json:"name"`
+	CredentialID    []byte         `db:"credential_id" json:"-"`
+	PublicKey       []byte         `db:"public_key" json:"-"`
+	AttestationType string         `db:"attestation_type" json:"attestation_type"`
+	AAGUID          []byte         `db:"aaguid" json:"-"`
+	SignCount       int64          `db:"sign_count" json:"sign_count"`
+	Transports      pq.StringArray `db:"transports" json:"transports"`
+	BackupEligible  bool           `db:"backup_eligible" json:"backup_eligible"`
+	BackupState     bool           `db:"backup_state" json:"backup_state"`
+	LastUsedAt      *time.Time     `db:"last_used_at" json:"last_used_at,omitempty"`
+	CreatedAt       time.Time      `db:"created_at" json:"created_at"`
+}
+
+///////////////////////////////////
+// WebAuthn Credential Functions //
+///////////////////////////////////
+
+// InsertWebAuthnCredential stores a newly registered credential for c.UserID
+func (db *DB) InsertWebAuthnCredential(c *WebAuthnCredential) (*WebAuthnCredential, error) {
+	w := new(WebAuthnCredential)
+	sql := `INSERT INTO webauthn_credentials (user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING *`
+	err := db.Get(w, sql, c.UserID, c.Name, c.CredentialID, c.PublicKey, c.AttestationType, c.AAGUID, c.SignCount, c.Transports, c.BackupEligible, c.BackupState)
+	return w, err
+}
+
+// GetWebAuthnCredentialsByUser returns every credential registered by the user
+func (db *DB) GetWebAuthnCredentialsByUser(user uuid.UUID) (*[]WebAuthnCredential, error) {
+	w := new([]WebAuthnCredential)
+	sql := "SELECT * FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at"
+	err := db.Select(w, sql, user)
+	return w, err
+}
+
+// RenameWebAuthnCredential renames a credential only if it belongs to the given user
+func (db *DB) RenameWebAuthnCredential(id uuid.UUID, user uuid.UUID, name string) (*WebAuthnCredential, error) {
+	w := new(WebAuthnCredential)
+	sql := "UPDATE webauthn_credentials SET name = $3 WHERE id = $1 AND user_id = $2 RETURNING *"
+	err := db.Get(w, sql, id, user, name)
+	return w, err
+}
+
+// DeleteWebAuthnCredential removes a credential only if it belongs to the given user
+func (db *DB) DeleteWebAuthnCredential(id uuid.UUID, user uuid.UUID) (*WebAuthnCredential, error) {
+	w := new(WebAuthnCredential)
+	sql := "DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2 RETURNING *"
+	err := db.Get(w, sql, id, user)
+	return w, err
+}
+
+// UseWebAuthnCredential records a login with the credential and its new signature counter,
+// returns an error if the counter did not increase (authenticators without a counter always
+// send 0) so a cloned authenticator or replayed assertion is rejected
+func (db *DB) UseWebAuthnCredential(credentialID []byte, signCount int64) (*WebAuthnCredential, error) {
+	w := new(WebAuthnCredential)
+	sql := `UPDATE webauthn_credentials SET sign_count = $2, last_used_at = NOW()
+		WHERE credential_id = $1 AND (sign_count < $2 OR (sign_count = 0 AND $2 = 0)) RETURNING *`
+	err := db.Get(w, sql, credentialID, signCount)
+	return w, err
+}
@@ -0,0 +1,56 @@
+package oidc
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Claims are the ID token claims used to identify and provision users
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+	Name              string   `json:"name"`
+}
+
+// Valid checks the expiry, it is called by jwt-go while parsing
+func (c *Claims) Valid() error {
+	if c.Subject == "" || c.ExpiresAt == 0 || time.Now().Add(-leeway).Unix() > c.ExpiresAt {
+		return ErrClaims
+	}
+	return nil
+}
+
+// audience is the aud claim which can be a single string or a list
+type audience []string
+
+// UnmarshalJSON accepts a string or a list of strings
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// contains reports whether the audience includes the client id
+func (a audience) contains(clientID string) bool {
+	for _, v := range a {
+		if v == clientID {
+			return true
+		}
+	}
+	return false
+}
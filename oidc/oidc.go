@@ -0,0 +1,193 @@
+// Package oidc implements the OpenID Connect authorization code flow with PKCE against a
+// single issuer. Endpoints are found through discovery and ID tokens are verified with the
+// issuer's published keys (RSA and ECDSA).
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// ErrIssuer is returned when the discovery document or an ID token names another issuer
+	ErrIssuer = errors.New("oidc: issuer mismatch")
+	// ErrNonce is returned when the ID token nonce does not match the one sent
+	ErrNonce = errors.New("oidc: nonce mismatch")
+	// ErrNoIDToken is returned when the token response has no id_token
+	ErrNoIDToken = errors.New("oidc: token response has no id_token")
+	// ErrClaims is returned when the audience or expiry of an ID token is invalid
+	ErrClaims = errors.New("oidc: invalid audience or expiry")
+)
+
+// leeway is the clock skew tolerated when checking the ID token expiry
+const leeway = time.Minute
+
+// signingMethods are the ID token algorithms accepted
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// Config is the client registration at the issuer
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Provider is an issuer discovered from its /.well-known/openid-configuration
+type Provider struct {
+	Config
+	AuthURL  string
+	TokenURL string
+	JWKSURL  string
+
+	client *http.Client
+	mu     sync.Mutex
+	keys   map[string]interface{}
+}
+
+// discovery is the part of the discovery document the provider uses
+type discovery struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+}
+
+// Discover fetches the issuer's discovery document, client defaults to http.DefaultClient
+func Discover(ctx context.Context, c Config, client *http.Client) (*Provider, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "email", "profile"}
+	}
+	d := new(discovery)
+	err := getJSON(ctx, client, strings.TrimSuffix(c.Issuer, "/")+"/.well-known/openid-configuration", d)
+	if err != nil {
+		return nil, err
+	}
+	if d.Issuer != c.Issuer {
+		return nil, ErrIssuer
+	}
+	if d.AuthURL == "" || d.TokenURL == "" || d.JWKSURL == "" {
+		return nil, errors.New("oidc: incomplete discovery document")
+	}
+	return &Provider{Config: c, AuthURL: d.AuthURL, TokenURL: d.TokenURL, JWKSURL: d.JWKSURL, client: client}, nil
+}
+
+// AuthCodeURL returns the address to send the user to, the issuer redirects back to
+// RedirectURL with the state and a code
+func (p *Provider) AuthCodeURL(state string, nonce string, verifier string) string {
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + v.Encode()
+}
+
+// tokenResponse is the part of the token endpoint response the provider uses
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+// Exchange redeems the code with the PKCE verifier and returns the verified ID token claims
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest("POST", p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	res, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	t := new(tokenResponse)
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(t)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: token endpoint returned %d %s", res.StatusCode, t.Error)
+	}
+	if t.IDToken == "" {
+		return nil, ErrNoIDToken
+	}
+	return p.Verify(ctx, t.IDToken, nonce)
+}
+
+// Verify checks the ID token signature, issuer, audience, expiry and nonce
+func (p *Provider) Verify(ctx context.Context, idToken string, nonce string) (*Claims, error) {
+	claims := new(Claims)
+	parser := &jwt.Parser{ValidMethods: signingMethods}
+	_, err := parser.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if claims.Issuer != p.Issuer {
+		return nil, ErrIssuer
+	}
+	if !claims.Audience.contains(p.ClientID) {
+		return nil, ErrClaims
+	}
+	if claims.Nonce != nonce {
+		return nil, ErrNonce
+	}
+	return claims, nil
+}
+
+// getJSON decodes the JSON response of a GET request to u into v
+func getJSON(ctx context.Context, client *http.Client, u string, v interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, res.Body)
+		return fmt.Errorf("oidc: GET %s returned %d", u, res.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,299 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const clientID = "mirango"
+
+// issuer is a stub OpenID Connect issuer, the token endpoint answers with idToken for code
+type issuer struct {
+	*httptest.Server
+	t        *testing.T
+	mu       sync.Mutex
+	keys     map[string]*rsa.PrivateKey
+	jwksHits int
+	code     string
+	verifier string
+	idToken  string
+}
+
+func newIssuer(t *testing.T) *issuer {
+	is := &issuer{t: t, keys: make(map[string]*rsa.PrivateKey)}
+	is.addKey("k1")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:   is.URL,
+			AuthURL:  is.URL + "/authorize",
+			TokenURL: is.URL + "/token",
+			JWKSURL:  is.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		is.mu.Lock()
+		defer is.mu.Unlock()
+		is.jwksHits++
+		var keys []jwk
+		for kid, k := range is.keys {
+			keys = append(keys, jwk{
+				Kid: kid,
+				Kty: "RSA",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		is.mu.Lock()
+		defer is.mu.Unlock()
+		id, secret, _ := r.BasicAuth()
+		if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != is.code ||
+			r.PostFormValue("code_verifier") != is.verifier || id != clientID || secret != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": is.idToken})
+	})
+	is.Server = httptest.NewServer(mux)
+	t.Cleanup(is.Close)
+	return is
+}
+
+// addKey publishes a new signing key
+func (is *issuer) addKey(kid string) {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		is.t.Fatal(err)
+	}
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	is.keys[kid] = k
+}
+
+// claims returns valid claims for the nonce
+func (is *issuer) claims(nonce string) *Claims {
+	return &Claims{
+		Issuer:        is.URL,
+		Subject:       "248289761001",
+		Audience:      audience{clientID},
+		ExpiresAt:     time.Now().Add(time.Hour).Unix(),
+		IssuedAt:      time.Now().Unix(),
+		Nonce:         nonce,
+		Email:         "alice@example.com",
+		EmailVerified: true,
+	}
+}
+
+// sign returns the claims signed with the key kid
+func (is *issuer) sign(kid string, c *Claims) string {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+	t.Header["kid"] = kid
+	s, err := t.SignedString(is.keys[kid])
+	if err != nil {
+		is.t.Fatal(err)
+	}
+	return s
+}
+
+// grant makes the token endpoint hand out idToken for code and verifier
+func (is *issuer) grant(code string, verifier string, idToken string) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	is.code, is.verifier, is.idToken = code, verifier, idToken
+}
+
+func (is *issuer) provider(t *testing.T) *Provider {
+	t.Helper()
+	p, err := Discover(context.Background(), Config{
+		Issuer:       is.URL,
+		ClientID:     clientID,
+		ClientSecret: "secret",
+		RedirectURL:  "https://blog.example.com/login/oidc/callback",
+	}, is.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestDiscover(t *testing.T) {
+	is := newIssuer(t)
+	p := is.provider(t)
+	if p.AuthURL != is.URL+"/authorize" || p.TokenURL != is.URL+"/token" || p.JWKSURL != is.URL+"/jwks" {
+		t.Errorf("unexpected endpoints %+v", p)
+	}
+	if len(p.Scopes) != 3 {
+		t.Errorf("default scopes = %v", p.Scopes)
+	}
+	// The discovery document has to be for the configured issuer
+	_, err := Discover(context.Background(), Config{Issuer: is.URL + "/other"}, is.Client())
+	if err == nil {
+		t.Error("discovered a missing document")
+	}
+	_, err = Discover(context.Background(), Config{Issuer: is.URL + "/"}, is.Client())
+	if err != ErrIssuer {
+		t.Errorf("got %v, want ErrIssuer", err)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	p := newIssuer(t).provider(t)
+	// RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	u, err := url.Parse(p.AuthCodeURL("state", "nonce", verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             clientID,
+		"redirect_uri":          p.RedirectURL,
+		"scope":                 "openid email profile",
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		"code_challenge_method": "S256",
+	}
+	for k, v := range want {
+		if q.Get(k) != v {
+			t.Errorf("%s = %q, want %q", k, q.Get(k), v)
+		}
+	}
+	if q.Get("code_verifier") != "" {
+		t.Error("the verifier was sent to the browser")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	is := newIssuer(t)
+	p := is.provider(t)
+	is.grant("code", "verifier", is.sign("k1", is.claims("nonce")))
+
+	claims, err := p.Exchange(context.Background(), "code", "verifier", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "248289761001" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if _, err := p.Exchange(context.Background(), "code", "wrong verifier", "nonce"); err == nil {
+		t.Error("exchanged a code with the wrong verifier")
+	}
+	if _, err := p.Exchange(context.Background(), "other code", "verifier", "nonce"); err == nil {
+		t.Error("exchanged an unknown code")
+	}
+	if _, err := p.Exchange(context.Background(), "code", "verifier", "other nonce"); err != ErrNonce {
+		t.Errorf("got %v, want ErrNonce", err)
+	}
+	is.grant("code", "verifier", "")
+	if _, err := p.Exchange(context.Background(), "code", "verifier", "nonce"); err != ErrNoIDToken {
+		t.Errorf("got %v, want ErrNoIDToken", err)
+	}
+}
+
+// inner returns the error a keyfunc or Valid returned from inside jwt-go's ValidationError
+func inner(err error) error {
+	if v, ok := err.(*jwt.ValidationError); ok && v.Inner != nil {
+		return v.Inner
+	}
+	return err
+}
+
+func TestVerify(t *testing.T) {
+	is := newIssuer(t)
+	p := is.provider(t)
+
+	expired := is.claims("nonce")
+	expired.ExpiresAt = time.Now().Add(-2 * leeway).Unix()
+	skewed := is.claims("nonce")
+	skewed.ExpiresAt = time.Now().Add(-leeway / 2).Unix()
+	otherIssuer := is.claims("nonce")
+	otherIssuer.Issuer = "https://evil.example.com"
+	otherAudience := is.claims("nonce")
+	otherAudience.Audience = audience{"someone else"}
+	sharedAudience := is.claims("nonce")
+	sharedAudience.Audience = audience{"someone else", clientID}
+	noSubject := is.claims("nonce")
+	noSubject.Subject = ""
+
+	tests := []struct {
+		name   string
+		claims *Claims
+		err    error
+	}{
+		{"valid", is.claims("nonce"), nil},
+		{"within clock skew", skewed, nil},
+		{"audience list", sharedAudience, nil},
+		{"expired", expired, ErrClaims},
+		{"no subject", noSubject, ErrClaims},
+		{"other issuer", otherIssuer, ErrIssuer},
+		{"other audience", otherAudience, ErrClaims},
+		{"wrong nonce", is.claims("other"), ErrNonce},
+	}
+	for _, tt := range tests {
+		_, err := p.Verify(context.Background(), is.sign("k1", tt.claims), "nonce")
+		if inner(err) != tt.err {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	// HMAC tokens are rejected so the public key cannot be used as a shared secret
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, is.claims("nonce"))
+	hs.Header["kid"] = "k1"
+	s, _ := hs.SignedString([]byte("secret"))
+	if _, err := p.Verify(context.Background(), s, "nonce"); err == nil {
+		t.Error("accepted an HS256 token")
+	}
+	if _, err := p.Verify(context.Background(), is.sign("k1", is.claims("nonce"))+"x", "nonce"); err == nil {
+		t.Error("accepted a token with a broken signature")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	is := newIssuer(t)
+	p := is.provider(t)
+	for i := 0; i < 2; i++ {
+		if _, err := p.Verify(context.Background(), is.sign("k1", is.claims("nonce")), "nonce"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if is.jwksHits != 1 {
+		t.Errorf("key set fetched %d times, want 1", is.jwksHits)
+	}
+
+	// A token with a new kid makes the provider fetch the key set again
+	is.addKey("k2")
+	if _, err := p.Verify(context.Background(), is.sign("k2", is.claims("nonce")), "nonce"); err != nil {
+		t.Fatal(err)
+	}
+	if is.jwksHits != 2 {
+		t.Errorf("key set fetched %d times, want 2", is.jwksHits)
+	}
+	c := is.claims("nonce")
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+	token.Header["kid"] = "k3"
+	s, _ := token.SignedString(is.keys["k1"])
+	if _, err := p.Verify(context.Background(), s, "nonce"); inner(err) != ErrUnknownKey {
+		t.Errorf("got %v, want ErrUnknownKey", err)
+	}
+}
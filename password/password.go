@@ -0,0 +1,185 @@
+// Package password hashes passwords into PHC formatted strings (argon2id by default, bcrypt
+// supported) and tells callers when a stored digest should be upgraded
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrFormat is returned for digests that are not in a supported format
+	ErrFormat = errors.New("password: unsupported digest format")
+	// ErrPepper is returned when a digest was made with a pepper version that is not configured
+	ErrPepper = errors.New("password: unknown pepper version")
+)
+
+var b64 = base64.RawStdEncoding
+
+// Hasher hashes passwords and verifies them against stored digests, rehash is set when the
+// digest was made with outdated settings and should be replaced by a new Hash
+type Hasher interface {
+	Hash(password string) (string, error)
+	Verify(password string, digest string) (ok bool, rehash bool, err error)
+}
+
+// Argon2Params are the argon2id cost parameters
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2 follows the RFC 9106 second recommended option
+var DefaultArgon2 = Argon2Params{Memory: 64 * 1024, Iterations: 3, Parallelism: 4, SaltLength: 16, KeyLength: 32}
+
+// PHC is the Hasher used by the application. Peppers are secrets kept outside the database
+// keyed by version, PepperID selects the version for new digests (0 disables the pepper).
+// LegacySalt is the global salt bare bcrypt digests were made with (password+salt).
+type PHC struct {
+	Algorithm  string // argon2id or bcrypt
+	Argon2     Argon2Params
+	BcryptCost int
+	Peppers    map[int][]byte
+	PepperID   int
+	LegacySalt string
+}
+
+// pepper mixes the versioned pepper into the password (keyed HMAC), version 0 is no pepper
+func (p *PHC) pepper(password string, id int) (string, error) {
+	if id == 0 {
+		return password, nil
+	}
+	secret, ok := p.Peppers[id]
+	if !ok {
+		return "", ErrPepper
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(password))
+	return b64.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Hash returns the PHC string for the password using the configured algorithm
+func (p *PHC) Hash(password string) (string, error) {
+	peppered, err := p.pepper(password, p.PepperID)
+	if err != nil {
+		return "", err
+	}
+	pepper := ""
+	if p.PepperID != 0 {
+		pepper = ",k=" + strconv.Itoa(p.PepperID)
+	}
+	switch p.Algorithm {
+	case "argon2id":
+		salt := make([]byte, p.Argon2.SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(peppered), salt, p.Argon2.Iterations, p.Argon2.Memory, p.Argon2.Parallelism, p.Argon2.KeyLength)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d%s$%s$%s", argon2.Version,
+			p.Argon2.Memory, p.Argon2.Iterations, p.Argon2.Parallelism, pepper,
+			b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+	case "bcrypt":
+		digest, err := bcrypt.GenerateFromPassword([]byte(peppered), p.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("$bcrypt$r=%d%s$%s", p.BcryptCost, pepper, b64.EncodeToString(digest)), nil
+	}
+	return "", fmt.Errorf("password: unsupported algorithm %q", p.Algorithm)
+}
+
+// Verify checks the password against a PHC digest or a bare bcrypt digest from before PHC
+// digests were introduced (always flagged for rehash)
+func (p *PHC) Verify(password string, digest string) (bool, bool, error) {
+	if strings.HasPrefix(digest, "$2a$") || strings.HasPrefix(digest, "$2b$") || strings.HasPrefix(digest, "$2y$") {
+		err := bcrypt.CompareHashAndPassword([]byte(digest), []byte(password+p.LegacySalt))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		}
+		return err == nil, true, err
+	}
+	// $alg$[v=version$]params$salt$hash
+	parts := strings.Split(digest, "$")
+	if len(parts) < 4 || parts[0] != "" {
+		return false, false, ErrFormat
+	}
+	alg, fields := parts[1], parts[2:]
+	if strings.HasPrefix(fields[0], "v=") {
+		fields = fields[1:]
+	}
+	params, err := parseParams(fields[0])
+	if err != nil {
+		return false, false, err
+	}
+	peppered, err := p.pepper(password, params["k"])
+	if err != nil {
+		return false, false, err
+	}
+	rehash := alg != p.Algorithm || params["k"] != p.PepperID
+	switch alg {
+	case "argon2id":
+		if len(fields) != 3 {
+			return false, false, ErrFormat
+		}
+		salt, err := b64.DecodeString(fields[1])
+		if err != nil {
+			return false, false, err
+		}
+		key, err := b64.DecodeString(fields[2])
+		if err != nil {
+			return false, false, err
+		}
+		if params["m"] < 1 || params["t"] < 1 || params["p"] < 1 || params["p"] > 255 {
+			return false, false, ErrFormat
+		}
+		computed := argon2.IDKey([]byte(peppered), salt, uint32(params["t"]), uint32(params["m"]), uint8(params["p"]), uint32(len(key)))
+		rehash = rehash || uint32(params["m"]) != p.Argon2.Memory || uint32(params["t"]) != p.Argon2.Iterations ||
+			uint8(params["p"]) != p.Argon2.Parallelism || uint32(len(key)) != p.Argon2.KeyLength
+		return subtle.ConstantTimeCompare(computed, key) == 1, rehash, nil
+	case "bcrypt":
+		if len(fields) != 2 {
+			return false, false, ErrFormat
+		}
+		raw, err := b64.DecodeString(fields[1])
+		if err != nil {
+			return false, false, err
+		}
+		err = bcrypt.CompareHashAndPassword(raw, []byte(peppered))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		}
+		rehash = rehash || params["r"] != p.BcryptCost
+		return err == nil, rehash, err
+	}
+	return false, false, ErrFormat
+}
+
+// parseParams parses the comma separated key=value parameters of a PHC string
+func parseParams(s string) (map[string]int, error) {
+	params := make(map[string]int)
+	for _, kv := range strings.Split(s, ",") {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 {
+			return nil, ErrFormat
+		}
+		v, err := strconv.Atoi(pair[1])
+		if err != nil {
+			return nil, ErrFormat
+		}
+		params[pair[0]] = v
+	}
+	return params, nil
+}
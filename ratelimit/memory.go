@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Memory is an in process Store (attempts are lost on restart and not shared between servers)
+type Memory struct {
+	mu       sync.Mutex
+	attempts map[string][]time.Time
+}
+
+// NewMemory returns an empty in memory Store
+func NewMemory() *Memory {
+	return &Memory{attempts: make(map[string][]time.Time)}
+}
+
+func memoryKey(bucket string, ip string) string {
+	return bucket + "|" + Root(ip)
+}
+
+// RecordAttempt stores an attempt for the bucket and IP root
+func (m *Memory) RecordAttempt(bucket string, ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memoryKey(bucket, ip)
+	m.attempts[key] = append(m.attempts[key], time.Now())
+	return nil
+}
+
+// CountAttempts returns the number of attempts since the given time and the oldest of them
+func (m *Memory) CountAttempts(bucket string, ip string, since time.Time) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	var oldest time.Time
+	for _, t := range m.attempts[memoryKey(bucket, ip)] {
+		if t.After(since) {
+			if count == 0 || t.Before(oldest) {
+				oldest = t
+			}
+			count++
+		}
+	}
+	return count, oldest, nil
+}
+
+// PruneAttempts removes attempts older than before and returns how many were removed
+func (m *Memory) PruneAttempts(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var pruned int64
+	for key, times := range m.attempts {
+		kept := times[:0]
+		for _, t := range times {
+			if t.After(before) {
+				kept = append(kept, t)
+			} else {
+				pruned++
+			}
+		}
+		if len(kept) == 0 {
+			delete(m.attempts, key)
+			continue
+		}
+		m.attempts[key] = kept
+	}
+	return pruned, nil
+}
@@ -0,0 +1,79 @@
+// Package ratelimit counts attempts per route and client network so brute force
+// attempts against sensitive routes can be throttled
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrIP is returned by Check when the client address is not an IP address
+var ErrIP = errors.New("ratelimit: invalid ip address")
+
+// Store records attempts and counts them per bucket and IP root. The PostgreSQL
+// implementation lives in models (ratelimits table) and Memory can replace it in tests.
+type Store interface {
+	RecordAttempt(bucket string, ip string) error
+	CountAttempts(bucket string, ip string, since time.Time) (int, time.Time, error)
+	PruneAttempts(before time.Time) (int64, error)
+}
+
+// Rule limits a bucket to Limit attempts per IP root during Window
+type Rule struct {
+	Bucket string
+	Limit  int
+	Window time.Duration
+}
+
+// Check returns how long the client has to wait before another attempt is allowed,
+// zero means the attempt is allowed and has been recorded
+func Check(s Store, rule Rule, ip string) (time.Duration, error) {
+	if net.ParseIP(ip) == nil {
+		return 0, ErrIP
+	}
+	now := time.Now()
+	count, oldest, err := s.CountAttempts(rule.Bucket, ip, now.Add(-rule.Window))
+	if err != nil {
+		return 0, err
+	}
+	if count >= rule.Limit {
+		wait := oldest.Add(rule.Window).Sub(now)
+		if wait < time.Second {
+			wait = time.Second
+		}
+		return wait, nil
+	}
+	return 0, s.RecordAttempt(rule.Bucket, ip)
+}
+
+// Root buckets IPv4 addresses by /24 and IPv6 addresses by /48 matching ip_root() in the database,
+// anything else is returned unchanged (Check rejects it)
+func Root(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// Prune deletes attempts older than keep every interval until the context is canceled
+// (run as a goroutine)
+func Prune(ctx context.Context, s Store, interval time.Duration, keep time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := s.PruneAttempts(now.Add(-keep)); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoot(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.17", "192.0.2.0"},
+		{"::ffff:192.0.2.17", "192.0.2.0"},
+		{"2001:db8:1234:5678::1", "2001:db8:1234::"},
+		{"not an ip", "not an ip"},
+	}
+	for _, tt := range tests {
+		if got := Root(tt.ip); got != tt.want {
+			t.Errorf("Root(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	m := NewMemory()
+	rule := Rule{Bucket: "login", Limit: 3, Window: time.Minute}
+	for i := 0; i < rule.Limit; i++ {
+		wait, err := Check(m, rule, "192.0.2.1")
+		if err != nil || wait != 0 {
+			t.Fatalf("attempt %d: wait %v, err %v", i+1, wait, err)
+		}
+	}
+	// The rest of the /24 shares the limit, other buckets and networks do not
+	wait, err := Check(m, rule, "192.0.2.200")
+	if err != nil || wait <= 0 || wait > rule.Window {
+		t.Errorf("attempt over the limit: wait %v, err %v", wait, err)
+	}
+	if wait, err := Check(m, Rule{Bucket: "account", Limit: 3, Window: time.Minute}, "192.0.2.1"); err != nil || wait != 0 {
+		t.Errorf("other bucket: wait %v, err %v", wait, err)
+	}
+	if wait, err := Check(m, rule, "198.51.100.1"); err != nil || wait != 0 {
+		t.Errorf("other network: wait %v, err %v", wait, err)
+	}
+	// Rejected attempts are not recorded
+	if count, _, _ := m.CountAttempts("login", "192.0.2.1", time.Now().Add(-time.Minute)); count != rule.Limit {
+		t.Errorf("recorded %d attempts, want %d", count, rule.Limit)
+	}
+}
+
+func TestCheckInvalidIP(t *testing.T) {
+	m := NewMemory()
+	rule := Rule{Bucket: "login", Limit: 1, Window: time.Minute}
+	for _, ip := range []string{"", "unknown", "192.0.2.1:1234"} {
+		if _, err := Check(m, rule, ip); err != ErrIP {
+			t.Errorf("Check(%q) error = %v, want ErrIP", ip, err)
+		}
+	}
+	if len(m.attempts) != 0 {
+		t.Error("invalid addresses were recorded")
+	}
+}
+
+func TestMemoryPrune(t *testing.T) {
+	m := NewMemory()
+	m.RecordAttempt("login", "192.0.2.1")
+	m.RecordAttempt("login", "198.51.100.1")
+	pruned, err := m.PruneAttempts(time.Now().Add(time.Second))
+	if err != nil || pruned != 2 {
+		t.Errorf("pruned %d, err %v, want 2", pruned, err)
+	}
+	if len(m.attempts) != 0 {
+		t.Error("pruned buckets were kept")
+	}
+	m.RecordAttempt("login", "192.0.2.1")
+	if pruned, _ := m.PruneAttempts(time.Now().Add(-time.Minute)); pruned != 0 {
+		t.Errorf("pruned %d recent attempts", pruned)
+	}
+}
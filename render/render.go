@@ -0,0 +1,64 @@
+// Package render turns post sources into the sanitized HTML that is stored and served.
+// Markdown is CommonMark with the GitHub extensions (tables, strikethrough, autolinks,
+// task lists) and footnotes, fenced code blocks get a language-* class for highlighters
+package render
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// Source formats a post can be written in
+const (
+	Markdown = "markdown"
+	HTML     = "html"
+)
+
+// ErrFormat is returned for source formats other than Markdown and HTML
+var ErrFormat = errors.New("unknown source format")
+
+// markdown renders raw HTML in the source as well, it is removed by the policy with
+// everything else that is not allowed
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM, extension.Footnote),
+	goldmark.WithRendererOptions(html.WithUnsafe()),
+)
+
+// policy is the UGC policy plus the attributes the Markdown renderer uses for code
+// blocks, footnotes and task lists
+var policy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#.-]+$`)).OnElements("code")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^footnote-(ref|backref)$`)).OnElements("a")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^footnotes$`)).OnElements("div", "section")
+	p.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-(noteref|backlink|endnotes)$`)).OnElements("a", "div", "section")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	return p
+}()
+
+// Sanitize removes everything from the HTML that is not allowed in a post
+func Sanitize(s string) string {
+	return policy.Sanitize(s)
+}
+
+// Render returns the sanitized HTML for the source written in format
+func Render(format string, source string) (string, error) {
+	switch format {
+	case Markdown:
+		var buf bytes.Buffer
+		if err := markdown.Convert([]byte(source), &buf); err != nil {
+			return "", err
+		}
+		return Sanitize(buf.String()), nil
+	case HTML:
+		return Sanitize(source), nil
+	}
+	return "", ErrFormat
+}
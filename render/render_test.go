@@ -0,0 +1,92 @@
+package render
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// formats maps the extensions of the sources in testdata to their format
+var formats = map[string]string{
+	".md":   Markdown,
+	".html": HTML,
+}
+
+// TestRenderGolden renders every source in testdata and compares the HTML with the
+// .golden file next to it, run with -update to rewrite them after an intended change
+func TestRenderGolden(t *testing.T) {
+	sources, err := filepath.Glob(filepath.Join("testdata", "*.*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, source := range sources {
+		ext := filepath.Ext(source)
+		format, ok := formats[ext]
+		if !ok {
+			continue
+		}
+		n++
+		name := strings.TrimSuffix(filepath.Base(source), ext) + "." + format
+		t.Run(name, func(t *testing.T) {
+			in, err := ioutil.ReadFile(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Render(format, string(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := strings.TrimSuffix(source, ext) + "." + format + ".golden"
+			if *update {
+				if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("Render(%q) differs from %s\ngot:\n%s\nwant:\n%s", format, golden, got, want)
+			}
+		})
+	}
+	if n == 0 {
+		t.Fatal("no sources in testdata")
+	}
+}
+
+func TestRenderUnknownFormat(t *testing.T) {
+	for _, format := range []string{"", "textile", "Markdown"} {
+		if _, err := Render(format, "text"); err != ErrFormat {
+			t.Errorf("Render(%q) error = %v, want ErrFormat", format, err)
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`<p>text</p>`, `<p>text</p>`},
+		{`<script>alert(1)</script>`, ``},
+		{`<a href="javascript:alert(1)">x</a>`, `x`},
+		{`<code class="language-c++">x</code>`, `<code class="language-c++">x</code>`},
+		{`<code class="highlight">x</code>`, `<code>x</code>`},
+		{`<a class="footnote-ref" href="#fn:1">1</a>`, `<a class="footnote-ref" href="#fn:1" rel="nofollow">1</a>`},
+		{`<input type="checkbox" checked="" disabled="">`, `<input type="checkbox" checked="" disabled="">`},
+		{`<input type="password">`, ``},
+	}
+	for _, tt := range tests {
+		if got := Sanitize(tt.in); got != tt.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
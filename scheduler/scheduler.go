@@ -0,0 +1,61 @@
+// Package scheduler publishes posts once their publish_at time has passed. Every server
+// instance can run it, due posts are claimed with SELECT ... FOR UPDATE SKIP LOCKED so
+// each one is published (and its hooks fired) exactly once
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/sdwalsh/mirango-go/models"
+)
+
+// Store publishes the due posts, implemented by models.DB
+type Store interface {
+	PublishDuePosts(limit int) (*[]models.Post, error)
+}
+
+// Hook is called after a post is published, by the scheduler or a user. Hooks run on the
+// goroutine that published the post and should hand slow work off
+type Hook func(p *models.Post)
+
+// Fire calls every hook with the post
+func Fire(hooks []Hook, p *models.Post) {
+	for _, h := range hooks {
+		h(p)
+	}
+}
+
+// Run publishes due posts right away and then every interval until the context is
+// canceled, batch posts at a time (run as a goroutine)
+func Run(ctx context.Context, s Store, interval time.Duration, batch int, hooks []Hook, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		publish(s, batch, hooks, onError)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publish publishes batches until no due posts are left
+func publish(s Store, batch int, hooks []Hook, onError func(error)) {
+	for {
+		posts, err := s.PublishDuePosts(batch)
+		if err != nil {
+			if onError != nil {
+				onError(err)
+			}
+			return
+		}
+		for i := range *posts {
+			Fire(hooks, &(*posts)[i])
+		}
+		if len(*posts) == 0 || len(*posts) < batch {
+			return
+		}
+	}
+}
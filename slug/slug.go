@@ -0,0 +1,60 @@
+// Package slug turns titles into lowercase ASCII url segments, accented letters are
+// transliterated (é becomes e, ß becomes ss) and everything else becomes a dash
+package slug
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxLength is the longest slug Make returns
+const MaxLength = 80
+
+// letters that do not decompose into an ASCII base letter and a combining mark
+var special = strings.NewReplacer(
+	"ß", "ss", "æ", "ae", "Æ", "ae", "œ", "oe", "Œ", "oe", "ø", "o", "Ø", "o",
+	"đ", "d", "Đ", "d", "ł", "l", "Ł", "l", "þ", "th", "Þ", "th", "ð", "d", "Ð", "d",
+	"&", " and ",
+)
+
+// Make returns the slug for s, it is empty if s has no letters or digits
+func Make(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	ascii, _, err := transform.String(t, special.Replace(s))
+	if err != nil {
+		ascii = s
+	}
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(ascii) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	slug := b.String()
+	if len(slug) > MaxLength {
+		slug = strings.TrimRight(slug[:MaxLength], "-")
+	}
+	return slug
+}
+
+// Suffix returns the nth alternative of a taken slug (slug-2, slug-3, ...) keeping it
+// within MaxLength
+func Suffix(slug string, n int) string {
+	suffix := "-" + strconv.Itoa(n)
+	if len(slug)+len(suffix) > MaxLength {
+		slug = strings.TrimRight(slug[:MaxLength-len(suffix)], "-")
+	}
+	return slug + suffix
+}
@@ -0,0 +1,72 @@
+package slug
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"  --Leading and trailing--  ", "leading-and-trailing"},
+		{"Crème brûlée à la carte", "creme-brulee-a-la-carte"},
+		{"Straße", "strasse"},
+		{"Ærø Łódź Þór", "aero-lodz-thor"},
+		{"Tom & Jerry", "tom-and-jerry"},
+		{"Rock'n'roll", "rock-n-roll"},
+		{"R&D", "r-and-d"},
+		{"Go 1.21 released", "go-1-21-released"},
+		{"日本語", ""},
+		{"!!!", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Make(tt.in); got != tt.want {
+			t.Errorf("Make(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMakeTruncates(t *testing.T) {
+	long := strings.Repeat("word ", 40)
+	got := Make(long)
+	if len(got) > MaxLength {
+		t.Errorf("Make returned %d bytes, want at most %d", len(got), MaxLength)
+	}
+	if strings.HasSuffix(got, "-") || !strings.HasPrefix(got, "word-word") {
+		t.Errorf("Make(%q) = %q", long, got)
+	}
+	// The cut lands right after a dash, which is trimmed
+	if got := Make(strings.Repeat("a", MaxLength-1) + " b"); got != strings.Repeat("a", MaxLength-1) {
+		t.Errorf("Make kept a trailing dash: %q", got)
+	}
+	if got := Make(strings.Repeat("x", MaxLength+10)); got != strings.Repeat("x", MaxLength) {
+		t.Errorf("Make did not cut at MaxLength: %q", got)
+	}
+}
+
+func TestSuffix(t *testing.T) {
+	tests := []struct {
+		slug string
+		n    int
+		want string
+	}{
+		{"foo", 2, "foo-2"},
+		{"foo-2", 2, "foo-2-2"},
+		{"foo", 10, "foo-10"},
+		{strings.Repeat("a", MaxLength), 2, strings.Repeat("a", MaxLength-2) + "-2"},
+		{strings.Repeat("a", MaxLength-3) + "-bc", 12, strings.Repeat("a", MaxLength-3) + "-12"},
+	}
+	for _, tt := range tests {
+		got := Suffix(tt.slug, tt.n)
+		if got != tt.want {
+			t.Errorf("Suffix(%q, %d) = %q, want %q", tt.slug, tt.n, got, tt.want)
+		}
+		if len(got) > MaxLength {
+			t.Errorf("Suffix(%q, %d) is %d bytes, want at most %d", tt.slug, tt.n, len(got), MaxLength)
+		}
+	}
+}
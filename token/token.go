@@ -0,0 +1,182 @@
+// Package token signs and verifies the JSON web tokens handed out at login. Keys are
+// identified by the kid header so retired keys keep verifying while a new key signs.
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// ErrUnknownKey is returned when the kid header does not match a key in the keyring
+	ErrUnknownKey = errors.New("token: unknown key id")
+	// ErrMethod is returned when the alg header does not match the key's algorithm
+	ErrMethod = errors.New("token: unexpected signing method")
+	// ErrClaims is returned when the issuer, audience or expiry of a token is invalid
+	ErrClaims = errors.New("token: invalid issuer, audience or expiry")
+	// ErrVerifyOnly is returned when signing with a key that only has a public key
+	ErrVerifyOnly = errors.New("token: key cannot sign")
+)
+
+// Key is a signing key identified by its kid
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewKey creates a key for the algorithm (HS256, HS512, ES256 or EdDSA). HMAC algorithms
+// use material as the secret, ES256 and EdDSA expect a PEM encoded private key (to sign
+// and verify) or public key (to only verify)
+func NewKey(id string, alg string, material []byte) (*Key, error) {
+	if id == "" {
+		return nil, errors.New("token: key id is required")
+	}
+	method := jwt.GetSigningMethod(alg)
+	switch alg {
+	case "HS256", "HS512":
+		if len(material) == 0 {
+			return nil, fmt.Errorf("token: empty secret for key %s", id)
+		}
+		return &Key{ID: id, Method: method, signKey: material, verifyKey: material}, nil
+	case "ES256":
+		if private, err := jwt.ParseECPrivateKeyFromPEM(material); err == nil {
+			return &Key{ID: id, Method: method, signKey: private, verifyKey: &private.PublicKey}, nil
+		}
+		public, err := jwt.ParseECPublicKeyFromPEM(material)
+		if err != nil {
+			return nil, fmt.Errorf("token: key %s: %v", id, err)
+		}
+		return &Key{ID: id, Method: method, verifyKey: public}, nil
+	case "EdDSA":
+		block, _ := pem.Decode(material)
+		if block == nil {
+			return nil, fmt.Errorf("token: key %s is not PEM encoded", id)
+		}
+		if private, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			if ed, ok := private.(ed25519.PrivateKey); ok {
+				return &Key{ID: id, Method: method, signKey: ed, verifyKey: ed.Public()}, nil
+			}
+			return nil, fmt.Errorf("token: key %s is not an Ed25519 key", id)
+		}
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("token: key %s: %v", id, err)
+		}
+		ed, ok := public.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("token: key %s is not an Ed25519 key", id)
+		}
+		return &Key{ID: id, Method: method, verifyKey: ed}, nil
+	}
+	return nil, fmt.Errorf("token: unsupported algorithm %q", alg)
+}
+
+// LoadKey creates a key from configuration, for HMAC algorithms material is the secret
+// otherwise it is the path of a PEM file
+func LoadKey(id string, alg string, material string) (*Key, error) {
+	if strings.HasPrefix(alg, "HS") {
+		return NewKey(id, alg, []byte(material))
+	}
+	b, err := ioutil.ReadFile(material)
+	if err != nil {
+		return nil, err
+	}
+	return NewKey(id, alg, b)
+}
+
+// CanSign reports whether the key holds a secret or private key
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+// verifier is implemented by claims embedding jwt.StandardClaims
+type verifier interface {
+	VerifyIssuer(cmp string, req bool) bool
+	VerifyAudience(cmp string, req bool) bool
+	VerifyExpiresAt(cmp int64, req bool) bool
+}
+
+// Keyring signs with its active key and verifies with any key it holds
+type Keyring struct {
+	Issuer   string
+	Audience string
+	active   *Key
+	keys     map[string]*Key
+}
+
+// NewKeyring returns a keyring that signs with active and also verifies tokens signed by
+// retired keys (keep them around until tokens signed with them have expired)
+func NewKeyring(issuer string, audience string, active *Key, retired ...*Key) (*Keyring, error) {
+	if !active.CanSign() {
+		return nil, ErrVerifyOnly
+	}
+	k := &Keyring{Issuer: issuer, Audience: audience, active: active, keys: make(map[string]*Key)}
+	for _, key := range append(retired, active) {
+		k.keys[key.ID] = key
+	}
+	return k, nil
+}
+
+// WithAudience returns a keyring sharing the same keys for tokens with a different audience,
+// tokens signed for one audience are rejected by the other
+func (k *Keyring) WithAudience(audience string) *Keyring {
+	return &Keyring{Issuer: k.Issuer, Audience: audience, active: k.active, keys: k.keys}
+}
+
+// Standard returns registered claims for a token with the given id that expires after ttl
+func (k *Keyring) Standard(id string, ttl time.Duration) jwt.StandardClaims {
+	now := time.Now()
+	return jwt.StandardClaims{
+		Id:        id,
+		Issuer:    k.Issuer,
+		Audience:  k.Audience,
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+}
+
+// Sign returns the signed token string using the active key
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	t := jwt.NewWithClaims(k.active.Method, claims)
+	t.Header["kid"] = k.active.ID
+	return t.SignedString(k.active.signKey)
+}
+
+// Parse verifies the token signature with the key named by its kid header and fills
+// claims, exp is required and iss / aud must match the keyring
+func (k *Keyring) Parse(tokenString string, claims jwt.Claims) error {
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := k.keys[kid]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		// Pin the algorithm to the key so an attacker cannot choose it
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, ErrMethod
+		}
+		return key.verifyKey, nil
+	})
+	if err != nil {
+		return err
+	}
+	v, ok := claims.(verifier)
+	if !ok {
+		return ErrClaims
+	}
+	if !v.VerifyExpiresAt(time.Now().Unix(), true) || !v.VerifyIssuer(k.Issuer, true) || !v.VerifyAudience(k.Audience, true) {
+		return ErrClaims
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// inner returns the error a keyfunc or claims check returned from inside jwt-go's
+// ValidationError
+func inner(err error) error {
+	if v, ok := err.(*jwt.ValidationError); ok && v.Inner != nil {
+		return v.Inner
+	}
+	return err
+}
+
+func hmacKey(t *testing.T, id string) *Key {
+	t.Helper()
+	k, err := NewKey(id, "HS256", []byte("secret for "+id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func keyring(t *testing.T, active *Key, retired ...*Key) *Keyring {
+	t.Helper()
+	k, err := NewKeyring("issuer", "audience", active, retired...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// pemKeys returns PEM encoded private and public keys
+func pemKeys(t *testing.T, private interface{}, public interface{}) ([]byte, []byte) {
+	t.Helper()
+	p, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: p}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})
+}
+
+func TestSignParse(t *testing.T) {
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPEM, _ := pemKeys(t, edPrivate, edPublic)
+	// jwt-go reads EC private keys in SEC 1 form
+	der, err := x509.MarshalECPrivateKey(ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	for _, tt := range []struct {
+		alg      string
+		material []byte
+	}{
+		{"HS256", []byte("secret")},
+		{"HS512", []byte("secret")},
+		{"ES256", ecPEM},
+		{"EdDSA", edPEM},
+	} {
+		key, err := NewKey("k1", tt.alg, tt.material)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.alg, err)
+		}
+		k := keyring(t, key)
+		s, err := k.Sign(k.Standard("id", time.Minute))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.alg, err)
+		}
+		var claims jwt.StandardClaims
+		if err := k.Parse(s, &claims); err != nil {
+			t.Errorf("%s: %v", tt.alg, err)
+		}
+		if claims.Id != "id" || claims.Issuer != "issuer" || claims.Audience != "audience" {
+			t.Errorf("%s: unexpected claims %+v", tt.alg, claims)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	old, next := hmacKey(t, "2023"), hmacKey(t, "2024")
+	before := keyring(t, old)
+	oldToken, err := before.Sign(before.Standard("old", time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After rotation new tokens carry the new kid and old tokens keep verifying
+	after := keyring(t, next, old)
+	newToken, err := after.Sign(after.Standard("new", time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, _ := new(jwt.Parser).ParseUnverified(newToken, &jwt.StandardClaims{})
+	if parsed.Header["kid"] != "2024" {
+		t.Errorf("new token kid = %v, want 2024", parsed.Header["kid"])
+	}
+	for _, s := range []string{oldToken, newToken} {
+		if err := after.Parse(s, &jwt.StandardClaims{}); err != nil {
+			t.Errorf("rotated keyring rejected a token: %v", err)
+		}
+	}
+	// Tokens of the new key are unknown to servers that have not rotated yet
+	if err := before.Parse(newToken, &jwt.StandardClaims{}); inner(err) != ErrUnknownKey {
+		t.Errorf("got %v, want ErrUnknownKey", err)
+	}
+	// Once the old key is dropped its tokens stop verifying
+	dropped := keyring(t, next)
+	if err := dropped.Parse(oldToken, &jwt.StandardClaims{}); inner(err) != ErrUnknownKey {
+		t.Errorf("got %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestTampered(t *testing.T) {
+	k := keyring(t, hmacKey(t, "k1"))
+	s, err := k.Sign(k.Standard("id", time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(s, ".")
+
+	// Changed claims no longer match the signature
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	forged := strings.Replace(string(payload), `"id"`, `"xx"`, 1)
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(forged)) + "." + parts[2]
+	if err := k.Parse(tampered, &jwt.StandardClaims{}); err == nil {
+		t.Error("accepted a token with changed claims")
+	}
+	if err := k.Parse(parts[0]+"."+parts[1]+".", &jwt.StandardClaims{}); err == nil {
+		t.Error("accepted a token without signature")
+	}
+
+	// A token signed with another secret under the same kid
+	other := keyring(t, &Key{ID: "k1", Method: jwt.SigningMethodHS256, signKey: []byte("other"), verifyKey: []byte("other")})
+	s, _ = other.Sign(other.Standard("id", time.Minute))
+	if err := k.Parse(s, &jwt.StandardClaims{}); err == nil {
+		t.Error("accepted a token signed with another secret")
+	}
+
+	// The algorithm is pinned to the key
+	hs512, _ := NewKey("k1", "HS512", []byte("secret for k1"))
+	s, _ = keyring(t, hs512).Sign(k.Standard("id", time.Minute))
+	if err := k.Parse(s, &jwt.StandardClaims{}); inner(err) != ErrMethod {
+		t.Errorf("got %v, want ErrMethod", err)
+	}
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, k.Standard("id", time.Minute))
+	none.Header["kid"] = "k1"
+	s, _ = none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err := k.Parse(s, &jwt.StandardClaims{}); err == nil {
+		t.Error("accepted an unsigned token")
+	}
+}
+
+func TestClaims(t *testing.T) {
+	k := keyring(t, hmacKey(t, "k1"))
+	sign := func(c jwt.StandardClaims) string {
+		s, err := k.Sign(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	expired := k.Standard("id", time.Minute)
+	expired.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	noExpiry := k.Standard("id", time.Minute)
+	noExpiry.ExpiresAt = 0
+	issuer := k.Standard("id", time.Minute)
+	issuer.Issuer = "someone else"
+	audience := k.Standard("id", time.Minute)
+	audience.Audience = "someone else"
+
+	for name, c := range map[string]jwt.StandardClaims{
+		"expired":      expired,
+		"no expiry":    noExpiry,
+		"wrong issuer": issuer,
+		"wrong aud":    audience,
+	} {
+		if err := k.Parse(sign(c), &jwt.StandardClaims{}); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	// Keyrings for another audience share the keys but reject each other's tokens
+	mfa := k.WithAudience("mfa")
+	s, _ := mfa.Sign(mfa.Standard("id", time.Minute))
+	if err := k.Parse(s, &jwt.StandardClaims{}); err != ErrClaims {
+		t.Errorf("got %v, want ErrClaims", err)
+	}
+	if err := mfa.Parse(s, &jwt.StandardClaims{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyOnlyKey(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePEM, publicPEM := pemKeys(t, private, public)
+	verifier, err := NewKey("old", "EdDSA", publicPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifier.CanSign() {
+		t.Error("public key can sign")
+	}
+	if _, err := NewKeyring("issuer", "audience", verifier); err != ErrVerifyOnly {
+		t.Errorf("got %v, want ErrVerifyOnly", err)
+	}
+
+	// A retired key only needs its public half to verify old tokens
+	signer, _ := NewKey("old", "EdDSA", privatePEM)
+	s, _ := keyring(t, signer).Sign(keyring(t, signer).Standard("id", time.Minute))
+	if err := keyring(t, hmacKey(t, "new"), verifier).Parse(s, &jwt.StandardClaims{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewKeyErrors(t *testing.T) {
+	for _, tt := range []struct {
+		id, alg  string
+		material []byte
+	}{
+		{"", "HS256", []byte("secret")},
+		{"k", "HS256", nil},
+		{"k", "RS256", []byte("secret")},
+		{"k", "ES256", []byte("not pem")},
+		{"k", "EdDSA", []byte("not pem")},
+	} {
+		if _, err := NewKey(tt.id, tt.alg, tt.material); err == nil {
+			t.Errorf("NewKey(%q, %q) accepted invalid input", tt.id, tt.alg)
+		}
+	}
+}
+
+func TestOpaque(t *testing.T) {
+	plain, hash, err := Opaque()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := Opaque()
+	if plain == other {
+		t.Error("opaque tokens repeat")
+	}
+	if string(Hash(plain)) != string(hash) {
+		t.Error("hash does not match the plain token")
+	}
+}
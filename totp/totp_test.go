@@ -0,0 +1,125 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the RFC 4226 / RFC 6238 SHA-1 test secret "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC4226(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for step, code := range want {
+		got, err := Code(rfcSecret, int64(step))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != code {
+			t.Errorf("Code(step %d) = %s, want %s", step, got, code)
+		}
+	}
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes, 6 digit codes are their last six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tt.code[2:]; got != want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := Step(now)
+	code, _ := Code(rfcSecret, step)
+	previous, _ := Code(rfcSecret, step-1)
+	next, _ := Code(rfcSecret, step+1)
+	stale, _ := Code(rfcSecret, step-2)
+
+	tests := []struct {
+		name string
+		code string
+		skew int
+		step int64
+		ok   bool
+	}{
+		{"current", code, 1, step, true},
+		{"surrounding whitespace", " " + code + "\n", 1, step, true},
+		{"previous within skew", previous, 1, step - 1, true},
+		{"next within skew", next, 1, step + 1, true},
+		{"previous without skew", previous, 0, 0, false},
+		{"outside skew", stale, 1, 0, false},
+		{"wrong code", "000000", 1, 0, false},
+		{"too short", code[:5], 1, 0, false},
+		{"too long", code + "0", 1, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := Validate(rfcSecret, tt.code, now, tt.skew)
+		if ok != tt.ok || got != tt.step {
+			t.Errorf("%s: Validate = (%d, %v), want (%d, %v)", tt.name, got, ok, tt.step, tt.ok)
+		}
+	}
+	// Lower case secrets are accepted, invalid secrets never validate
+	if _, ok := Validate(strings.ToLower(rfcSecret), code, now, 0); !ok {
+		t.Error("lower case secret rejected")
+	}
+	if _, ok := Validate("not base32!", code, now, 1); ok {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestStep(t *testing.T) {
+	for unix, want := range map[int64]int64{0: 0, 29: 0, 30: 1, 59: 1, 60: 2} {
+		if got := Step(time.Unix(unix, 0)); got != want {
+			t.Errorf("Step(%d) = %d, want %d", unix, got, want)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	s, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := encoding.DecodeString(s)
+	if err != nil || len(key) != 20 {
+		t.Errorf("secret %q decodes to %d bytes, err %v", s, len(key), err)
+	}
+	other, _ := GenerateSecret()
+	if s == other {
+		t.Error("secrets repeat")
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("mirango", "alice@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/mirango:alice@example.com" {
+		t.Errorf("unexpected URI %s", u)
+	}
+	q := u.Query()
+	if q.Get("secret") != rfcSecret || q.Get("issuer") != "mirango" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("unexpected parameters %v", q)
+	}
+}